@@ -8,12 +8,15 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
 type ArtifactManager struct {
 	nameToLocalDirs  map[string]string
 	nameToLocalFiles map[string]string
 	exports          []ExportArtifact
+	// mu protects the maps because the independent preSteps are built in parallel.
+	mu sync.RWMutex
 }
 
 func NewArtifactManager(exports []ExportArtifact) *ArtifactManager {
@@ -24,8 +27,16 @@ func NewArtifactManager(exports []ExportArtifact) *ArtifactManager {
 	}
 }
 
+// AddArtifacts registers the directory to store the artifacts.
+// The step that has strategy builds tasks with the same artifacts for each pod and for retest,
+// so the registered artifact keeps its directory to merge the artifacts of all containers.
 func (m *ArtifactManager) AddArtifacts(artifacts []ArtifactSpec) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	for _, artifact := range artifacts {
+		if _, exists := m.nameToLocalDirs[artifact.Name]; exists {
+			continue
+		}
 		dir, err := os.MkdirTemp("", "artifact")
 		if err != nil {
 			return fmt.Errorf("kubetest: failed to create temporary directory for artifact: %w", err)
@@ -37,6 +48,8 @@ func (m *ArtifactManager) AddArtifacts(artifacts []ArtifactSpec) error {
 }
 
 func (m *ArtifactManager) ExportPathByName(name string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	dir, exists := m.nameToLocalDirs[name]
 	if !exists {
 		return "", fmt.Errorf("kubetest: failed to find src path to export artifact by %s", name)
@@ -44,7 +57,20 @@ func (m *ArtifactManager) ExportPathByName(name string) (string, error) {
 	return dir, nil
 }
 
+// LocalDirByName returns the directory that has the artifact for each container.
+func (m *ArtifactManager) LocalDirByName(name string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	dir, exists := m.nameToLocalDirs[name]
+	if !exists {
+		return "", fmt.Errorf("kubetest: failed to find local artifact directory by %s", name)
+	}
+	return dir, nil
+}
+
 func (m *ArtifactManager) LocalPathByName(ctx context.Context, name string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	dir, exists := m.nameToLocalDirs[name]
 	if !exists {
 		return "", fmt.Errorf("kubetest: failed to find local artifact directory by %s", name)
@@ -73,6 +99,8 @@ func (m *ArtifactManager) LocalPathByName(ctx context.Context, name string) (str
 }
 
 func (m *ArtifactManager) LocalPathByNameAndContainerName(name, containerName string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	dir, exists := m.nameToLocalDirs[name]
 	if !exists {
 		return "", fmt.Errorf("kubetest: failed to find local artifact directory by %s", name)
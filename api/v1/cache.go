@@ -0,0 +1,278 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// CacheBackend stores the artifacts of preStep by the cache key.
+// The artifacts are passed as the map from the artifact name to the local directory managed by ArtifactManager.
+type CacheBackend interface {
+	// Restore copies the cached artifacts of the key to dstDirs. Returns false if some of the artifacts aren't cached.
+	Restore(ctx context.Context, key string, dstDirs map[string]string) (bool, error)
+	// Save stores the artifacts in srcDirs as the cache of the key.
+	Save(ctx context.Context, key string, srcDirs map[string]string) error
+}
+
+func newCacheBackend(store CacheStore) (CacheBackend, error) {
+	if store.Local != nil {
+		return &localCacheBackend{path: store.Local.Path}, nil
+	}
+	return nil, fmt.Errorf("kubetest: cache store must be specified")
+}
+
+// localCacheBackend stores the artifacts to <path>/<key>/<artifact name>.
+type localCacheBackend struct {
+	path string
+}
+
+func (b *localCacheBackend) Restore(ctx context.Context, key string, dstDirs map[string]string) (bool, error) {
+	keyDir := filepath.Join(b.path, key)
+	for name := range dstDirs {
+		if !existsDir(filepath.Join(keyDir, name)) {
+			return false, nil
+		}
+	}
+	for name, dstDir := range dstDirs {
+		srcDir := filepath.Join(keyDir, name)
+		paths, err := filepath.Glob(filepath.Join(srcDir, "*"))
+		if err != nil {
+			return false, fmt.Errorf("kubetest: failed to get cached artifact %s: %w", name, err)
+		}
+		for _, path := range paths {
+			LoggerFromContext(ctx).Debug("restore cache: copy from %s to %s", path, dstDir)
+			if err := localCopy(path, filepath.Join(dstDir, filepath.Base(path))); err != nil {
+				return false, fmt.Errorf("kubetest: failed to restore cached artifact %s: %w", name, err)
+			}
+		}
+	}
+	return true, nil
+}
+
+func (b *localCacheBackend) Save(ctx context.Context, key string, srcDirs map[string]string) error {
+	keyDir := filepath.Join(b.path, key)
+	if existsDir(keyDir) {
+		// the other run has already stored the cache of the same key.
+		return nil
+	}
+	if err := os.MkdirAll(b.path, 0755); err != nil {
+		return fmt.Errorf("kubetest: failed to create cache directory %s: %w", b.path, err)
+	}
+	// the artifacts are copied to the temporary directory and renamed to the key at once,
+	// so the other runs never restore the partially stored cache.
+	tmpDir, err := os.MkdirTemp(b.path, ".tmp-")
+	if err != nil {
+		return fmt.Errorf("kubetest: failed to create temporary directory for cache: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	for name, srcDir := range srcDirs {
+		LoggerFromContext(ctx).Debug("save cache: copy from %s", srcDir)
+		if err := localCopy(srcDir, filepath.Join(tmpDir, name)); err != nil {
+			return fmt.Errorf("kubetest: failed to save artifact %s to cache: %w", name, err)
+		}
+	}
+	if err := os.Rename(tmpDir, keyDir); err != nil {
+		if existsDir(keyDir) {
+			// the other run has stored the cache of the same key while copying.
+			return nil
+		}
+		return fmt.Errorf("kubetest: failed to save cache %s: %w", key, err)
+	}
+	return nil
+}
+
+// preStepCacheKey expands the key template of the cache by the cloned repositories.
+func preStepCacheKey(mgr *ResourceManager, cache *PreStepCache) (string, error) {
+	tmpl, err := newCacheKeyTemplate(
+		cache.Key,
+		mgr.RepositoryCommitHashByName,
+		func(repo string, patterns ...string) (string, error) {
+			repoPath, err := mgr.RepositoryFilePathByName(repo, ".")
+			if err != nil {
+				return "", err
+			}
+			return hashFiles(repoPath, patterns)
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, nil); err != nil {
+		return "", fmt.Errorf("kubetest: failed to expand cache key %s: %w", cache.Key, err)
+	}
+	key := b.String()
+	if key == "" || key == "." || key == ".." || strings.ContainsAny(key, `/\`) {
+		return "", fmt.Errorf("kubetest: cache key %q is invalid. it must be used as the directory name", key)
+	}
+	return key, nil
+}
+
+func newCacheKeyTemplate(key string, commit func(string) (string, error), hashFiles func(string, ...string) (string, error)) (*template.Template, error) {
+	tmpl, err := template.New("key").Option("missingkey=error").Funcs(template.FuncMap{
+		"commit":    commit,
+		"hashFiles": hashFiles,
+	}).Parse(key)
+	if err != nil {
+		return nil, fmt.Errorf("kubetest: failed to parse cache key %s: %w", key, err)
+	}
+	return tmpl, nil
+}
+
+// hashFiles returns sha256 hash of the paths and the contents of the files that match the glob patterns in the directory.
+func hashFiles(dir string, patterns []string) (string, error) {
+	if len(patterns) == 0 {
+		return "", fmt.Errorf("kubetest: hashFiles requires glob patterns of files")
+	}
+	matchers := make([]func(string) bool, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := globRegexp(pattern)
+		if err != nil {
+			return "", err
+		}
+		matchers = append(matchers, re.MatchString)
+	}
+	files := []string{}
+	if err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		for _, match := range matchers {
+			if match(rel) {
+				files = append(files, rel)
+				break
+			}
+		}
+		return nil
+	}); err != nil {
+		return "", fmt.Errorf("kubetest: failed to find files to hash: %w", err)
+	}
+	if len(files) == 0 {
+		return "", fmt.Errorf("kubetest: couldn't find files that match %s", strings.Join(patterns, ", "))
+	}
+	sort.Strings(files)
+	h := sha256.New()
+	for _, file := range files {
+		fmt.Fprintf(h, "%s\n", file)
+		f, err := os.Open(filepath.Join(dir, filepath.FromSlash(file)))
+		if err != nil {
+			return "", fmt.Errorf("kubetest: failed to open %s to hash: %w", file, err)
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", fmt.Errorf("kubetest: failed to read %s to hash: %w", file, err)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// restorePreStepCache restores the cached artifacts of preStep. Returns the cache key and whether the cache was hit.
+// If the cache store is broken, the cache is regarded as not found so that the step runs.
+func (r *Runner) restorePreStepCache(ctx context.Context, builder *TaskBuilder, step PreStep) (string, bool, error) {
+	key, err := preStepCacheKey(builder.mgr, step.Cache)
+	if err != nil {
+		return "", false, err
+	}
+	backend, err := newCacheBackend(step.Cache.Store)
+	if err != nil {
+		return "", false, err
+	}
+	artifactMgr := builder.mgr.artifactMgr
+	if err := artifactMgr.AddArtifacts(step.Template.Spec.Artifacts); err != nil {
+		return "", false, err
+	}
+	dirs, err := preStepArtifactDirs(artifactMgr, step)
+	if err != nil {
+		return "", false, err
+	}
+	found, err := restoreCache(ctx, backend, key, dirs)
+	if err != nil {
+		return "", false, err
+	}
+	return key, found, nil
+}
+
+// restoreCache restores the artifacts by the backend. If the cache isn't found or the cache store is broken,
+// the files restored partially are removed so that they don't mix with the artifacts created by the step.
+func restoreCache(ctx context.Context, backend CacheBackend, key string, dstDirs map[string]string) (bool, error) {
+	found, err := backend.Restore(ctx, key, dstDirs)
+	if err != nil {
+		// run the step instead of using the broken cache.
+		LoggerFromContext(ctx).Warn("failed to restore cache %s: %s", key, err.Error())
+		found = false
+	}
+	if found {
+		return true, nil
+	}
+	for _, dir := range dstDirs {
+		if err := removeDirContents(dir); err != nil {
+			return false, fmt.Errorf("kubetest: failed to clean up artifact directory %s for cache %s: %w", dir, key, err)
+		}
+	}
+	return false, nil
+}
+
+func removeDirContents(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// savePreStepCache stores the artifacts of preStep by the cache key.
+func (r *Runner) savePreStepCache(ctx context.Context, builder *TaskBuilder, step PreStep, key string) error {
+	backend, err := newCacheBackend(step.Cache.Store)
+	if err != nil {
+		return err
+	}
+	dirs, err := preStepArtifactDirs(builder.mgr.artifactMgr, step)
+	if err != nil {
+		return err
+	}
+	return backend.Save(ctx, key, dirs)
+}
+
+func preStepArtifactDirs(artifactMgr *ArtifactManager, step PreStep) (map[string]string, error) {
+	dirs := make(map[string]string, len(step.Template.Spec.Artifacts))
+	for _, artifact := range step.Template.Spec.Artifacts {
+		dir, err := artifactMgr.LocalDirByName(artifact.Name)
+		if err != nil {
+			return nil, err
+		}
+		dirs[artifact.Name] = dir
+	}
+	return dirs, nil
+}
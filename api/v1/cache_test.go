@@ -0,0 +1,175 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPreStepCache(t *testing.T) {
+	t.Run("HashFiles", func(t *testing.T) {
+		dir, err := os.MkdirTemp("", "hashfiles")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		writeFile := func(path, content string) {
+			path = filepath.Join(dir, path)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		writeFile("go.sum", "sum")
+		writeFile("pkg/foo/go.mod", "mod")
+		writeFile(".git/config", "config")
+		hash, err := hashFiles(dir, []string{"go.sum", "**/go.mod"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeFile("README.md", "readme")
+		writeFile(".git/config", "changed")
+		if unchanged, err := hashFiles(dir, []string{"go.sum", "**/go.mod"}); err != nil || unchanged != hash {
+			t.Fatalf("hash must not be changed by the unmatched files: %s %s %v", hash, unchanged, err)
+		}
+		writeFile("pkg/foo/go.mod", "changed")
+		if changed, err := hashFiles(dir, []string{"go.sum", "**/go.mod"}); err != nil || changed == hash {
+			t.Fatalf("hash must be changed by the contents of the file: %s %s %v", hash, changed, err)
+		}
+		if _, err := hashFiles(dir, []string{"unknown"}); err == nil {
+			t.Fatal("expected error for unmatched patterns")
+		}
+	})
+	t.Run("LocalCacheBackend", func(t *testing.T) {
+		dir, err := os.MkdirTemp("", "cache")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		srcDir := filepath.Join(dir, "src")
+		if err := os.MkdirAll(filepath.Join(srcDir, "build"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(srcDir, "build", "bin"), []byte("bin"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		backend := &localCacheBackend{path: filepath.Join(dir, "store")}
+		ctx := WithLogger(context.Background(), NewLogger(os.Stdout, LogLevelDebug))
+		dstDir := filepath.Join(dir, "dst")
+		if err := os.MkdirAll(dstDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if found, err := backend.Restore(ctx, "key", map[string]string{"bin": dstDir}); err != nil || found {
+			t.Fatalf("expected cache miss: %v %v", found, err)
+		}
+		if err := backend.Save(ctx, "key", map[string]string{"bin": srcDir}); err != nil {
+			t.Fatal(err)
+		}
+		if found, err := backend.Restore(ctx, "key", map[string]string{"bin": dstDir, "unknown": dstDir}); err != nil || found {
+			t.Fatalf("expected cache miss for the artifact that isn't cached: %v %v", found, err)
+		}
+		if found, err := backend.Restore(ctx, "key", map[string]string{"bin": dstDir}); err != nil || !found {
+			t.Fatalf("expected cache hit: %v %v", found, err)
+		}
+		content, err := os.ReadFile(filepath.Join(dstDir, "build", "bin"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "bin" {
+			t.Fatalf("failed to restore artifact: %q", content)
+		}
+	})
+	t.Run("RestoreBrokenCache", func(t *testing.T) {
+		dir, err := os.MkdirTemp("", "cache")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		ctx := WithLogger(context.Background(), NewLogger(os.Stdout, LogLevelDebug))
+		dstDirs := map[string]string{"bin": filepath.Join(dir, "bin")}
+		if err := os.MkdirAll(dstDirs["bin"], 0755); err != nil {
+			t.Fatal(err)
+		}
+		found, err := restoreCache(ctx, &brokenCacheBackend{}, "key", dstDirs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found {
+			t.Fatal("broken cache must be regarded as not found")
+		}
+		entries, err := os.ReadDir(dstDirs["bin"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 0 {
+			t.Fatalf("partially restored files must be removed: %d files remain", len(entries))
+		}
+	})
+	t.Run("SaveExistingKey", func(t *testing.T) {
+		dir, err := os.MkdirTemp("", "cache")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		ctx := WithLogger(context.Background(), NewLogger(os.Stdout, LogLevelDebug))
+		backend := &localCacheBackend{path: filepath.Join(dir, "store")}
+		if err := os.MkdirAll(filepath.Join(backend.path, "key"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		// the source directory doesn't exist, so Save fails if it copies the artifacts.
+		if err := backend.Save(ctx, "key", map[string]string{"bin": filepath.Join(dir, "unknown")}); err != nil {
+			t.Fatalf("artifacts must not be copied for the cached key: %v", err)
+		}
+	})
+	t.Run("ValidatePreStepCache", func(t *testing.T) {
+		prestep := func(key string) PreStep {
+			return PreStep{
+				Name: "build",
+				Template: TestJobTemplateSpec{
+					Spec: TestJobPodSpec{Artifacts: []ArtifactSpec{{Name: "bin"}}},
+				},
+				Cache: &PreStepCache{
+					Key:   key,
+					Store: CacheStore{Local: &LocalCacheStore{Path: "/cache"}},
+				},
+			}
+		}
+		for _, test := range []struct {
+			key   string
+			valid bool
+		}{
+			{key: `build-{{ commit "repo" }}-{{ hashFiles "repo" "go.sum" "**/go.mod" }}`, valid: true},
+			{key: "build-v1", valid: true},
+			{key: `build-{{ commit "unknown" }}`},
+			{key: `build-{{ hashFiles "repo" }}`},
+			{key: `build-{{ unknown }}`},
+			{key: ""},
+		} {
+			v := NewValidator()
+			v.repoNameMap["repo"] = struct{}{}
+			if err := v.ValidatePreStepCache(prestep(test.key)); test.valid != (err == nil) {
+				t.Fatalf("%s: unexpected validation result: %v", test.key, err)
+			}
+		}
+	})
+}
+
+// brokenCacheBackend restores a part of the artifacts and fails.
+type brokenCacheBackend struct{}
+
+func (b *brokenCacheBackend) Restore(ctx context.Context, key string, dstDirs map[string]string) (bool, error) {
+	for _, dir := range dstDirs {
+		if err := os.WriteFile(filepath.Join(dir, "partial"), []byte("partial"), 0644); err != nil {
+			return false, err
+		}
+	}
+	return false, errors.New("broken")
+}
+
+func (b *brokenCacheBackend) Save(ctx context.Context, key string, srcDirs map[string]string) error {
+	return nil
+}
@@ -4,17 +4,22 @@
 package v1
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/goccy/kubejob"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 )
 
@@ -25,10 +30,13 @@ type Job interface {
 	PreInit(TestJobContainer, PreInitCallback)
 	RunWithExecutionHandler(context.Context, func([]JobExecutor) error) error
 	Mount(func(ctx context.Context, exec JobExecutor, isInitContainer bool) error)
+	// FailOnImagePullBackOff stops the job with ImagePullBackOffError if the container can't pull the image.
+	FailOnImagePullBackOff()
 }
 
 type JobExecutor interface {
 	Output(context.Context) ([]byte, error)
+	ExecWithEnv(context.Context, []corev1.EnvVar) ([]byte, error)
 	ExecAsync(context.Context)
 	TerminationLog(context.Context, string) error
 	Stop(context.Context) error
@@ -36,6 +44,10 @@ type JobExecutor interface {
 	CopyTo(context.Context, string, string) error
 	Container() corev1.Container
 	Pod() *corev1.Pod
+	// LatestPod returns the latest state of the pod. If the pod doesn't exist on the cluster, returns nil.
+	LatestPod(context.Context) (*corev1.Pod, error)
+	// ResourceUsage returns the resource usage of the last command run by Output or ExecWithEnv. If it isn't measured, returns nil.
+	ResourceUsage() *ResourceUsage
 	PrepareCommand([]string) ([]byte, error)
 }
 
@@ -53,14 +65,18 @@ func NewJobBuilder(cfg *rest.Config, namespace string, runMode RunMode) *JobBuil
 	}
 }
 
-func (b *JobBuilder) BuildWithJob(jobSpec *batchv1.Job, containerNameToInstalledPathMap map[string]string, sharedAgentSpec *TestAgentSpec) (Job, error) {
+// BuildWithJob builds the job. keyContainers is the map from the name of the container that runs keys to whether it's the worker container of queue mode.
+func (b *JobBuilder) BuildWithJob(jobSpec *batchv1.Job, containerNameToInstalledPathMap map[string]string, sharedAgentSpec *TestAgentSpec, keyContainers map[string]bool) (Job, error) {
 	switch b.runMode {
 	case RunModeKubernetes:
+		var agentConfig *kubejob.AgentConfig
+		if sharedAgentSpec != nil {
+			measureResourceUsageByAgent(jobSpec, containerNameToInstalledPathMap, keyContainers)
+		}
 		job, err := kubejob.NewJobBuilder(b.cfg, b.namespace).BuildWithJob(jobSpec)
 		if err != nil {
 			return nil, err
 		}
-		var agentConfig *kubejob.AgentConfig
 		if sharedAgentSpec != nil {
 			cfg, err := kubejob.NewAgentConfig(containerNameToInstalledPathMap)
 			if err != nil {
@@ -75,7 +91,10 @@ func (b *JobBuilder) BuildWithJob(jobSpec *batchv1.Job, containerNameToInstalled
 			job.UseAgent(cfg)
 			agentConfig = cfg
 		}
-		return newKubernetesJob(job, agentConfig), nil
+		k8sJob := newKubernetesJob(job, agentConfig)
+		k8sJob.cfg = b.cfg
+		k8sJob.namespace = b.namespace
+		return k8sJob, nil
 	case RunModeLocal:
 		rootDir, err := os.MkdirTemp("", "root")
 		if err != nil {
@@ -88,11 +107,37 @@ func (b *JobBuilder) BuildWithJob(jobSpec *batchv1.Job, containerNameToInstalled
 	return nil, fmt.Errorf("kubetest: unknown run mode %v", b.runMode)
 }
 
+// measureResourceUsageByAgent runs the command of the container that runs keys via kubetest-agent
+// so that kubetest-agent reports the resource usage of the command. The sidecar containers aren't measured.
+// The worker container runs multiple keys, so its resource usage is measured for each process instead of the container.
+func measureResourceUsageByAgent(jobSpec *batchv1.Job, containerNameToInstalledPathMap map[string]string, keyContainers map[string]bool) {
+	containers := jobSpec.Spec.Template.Spec.Containers
+	for idx := range containers {
+		isWorker, isKeyContainer := keyContainers[containers[idx].Name]
+		if !isKeyContainer {
+			continue
+		}
+		installedPath, exists := containerNameToInstalledPathMap[containers[idx].Name]
+		if !exists || len(containers[idx].Command) == 0 {
+			continue
+		}
+		cmd := []string{installedPath, ResourceUsageCommand}
+		if isWorker {
+			cmd = append(cmd, ResourceUsageProcessFlag)
+		}
+		cmd = append(cmd, "--")
+		containers[idx].Command = append(cmd, containers[idx].Command...)
+	}
+}
+
 type kubernetesJob struct {
 	preInitCallbackContext context.Context
 	job                    *kubejob.Job
 	agentConfig            *kubejob.AgentConfig
 	mountCallback          func(context.Context, JobExecutor, bool) error
+	cfg                    *rest.Config
+	namespace              string
+	failOnImagePullBackOff bool
 }
 
 var defaultMountCallback = func(context.Context, JobExecutor, bool) error { return nil }
@@ -119,8 +164,95 @@ func (j *kubernetesJob) Mount(cb func(context.Context, JobExecutor, bool) error)
 	j.mountCallback = cb
 }
 
+func (j *kubernetesJob) FailOnImagePullBackOff() {
+	j.failOnImagePullBackOff = true
+}
+
 func (j *kubernetesJob) RunWithExecutionHandler(ctx context.Context, handler func([]JobExecutor) error) error {
+	if !j.failOnImagePullBackOff {
+		return j.runWithExecutionHandler(ctx, handler)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	watchErr := make(chan error, 1)
+	go func() {
+		err := j.watchImagePullBackOff(ctx)
+		if err != nil {
+			cancel()
+		}
+		watchErr <- err
+	}()
+	err := j.runWithExecutionHandler(ctx, handler)
+	cancel()
+	if imagePullErr := <-watchErr; imagePullErr != nil {
+		return imagePullErr
+	}
+	return err
+}
+
+// watchImagePullBackOff watches the pod of the job until ctx is done.
+// If the container of the pod is waiting by image pull backoff, returns ImagePullBackOffError.
+func (j *kubernetesJob) watchImagePullBackOff(ctx context.Context) error {
+	const (
+		watchInterval              = 5 * time.Second
+		waitingReasonImagePullBack = "ImagePullBackOff"
+	)
+	podClient, err := j.podClient()
+	if err != nil {
+		LoggerFromContext(ctx).Warn("failed to create clientset to watch image pull backoff: %s", err.Error())
+		return nil
+	}
+	selector := fmt.Sprintf("%s=%s", kubejob.SelectorLabel, j.job.Spec.Template.Labels[kubejob.SelectorLabel])
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		pods, err := podClient.List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			continue
+		}
+		for _, pod := range pods.Items {
+			statuses := make([]corev1.ContainerStatus, 0, len(pod.Status.InitContainerStatuses)+len(pod.Status.ContainerStatuses))
+			statuses = append(statuses, pod.Status.InitContainerStatuses...)
+			statuses = append(statuses, pod.Status.ContainerStatuses...)
+			for _, status := range statuses {
+				waiting := status.State.Waiting
+				if waiting == nil || waiting.Reason != waitingReasonImagePullBack {
+					continue
+				}
+				return &ImagePullBackOffError{
+					Pod:       pod.Name,
+					Container: status.Name,
+					Image:     status.Image,
+					Message:   waiting.Message,
+				}
+			}
+		}
+	}
+}
+
+func (j *kubernetesJob) podClient() (typedcorev1.PodInterface, error) {
+	if j.cfg == nil {
+		return nil, fmt.Errorf("kubetest: rest config is not specified")
+	}
+	clientset, err := kubernetes.NewForConfig(j.cfg)
+	if err != nil {
+		return nil, err
+	}
+	return clientset.CoreV1().Pods(j.namespace), nil
+}
+
+func (j *kubernetesJob) runWithExecutionHandler(ctx context.Context, handler func([]JobExecutor) error) error {
 	j.preInitCallbackContext = ctx
+	// podClient is used to get the termination reason of the container.
+	podClient, err := j.podClient()
+	if err != nil {
+		LoggerFromContext(ctx).Warn("failed to create clientset to get pod status: %s", err.Error())
+	}
 	j.job.DisableInitContainerLog()
 	j.job.SetPendingPhaseTimeout(5 * time.Minute)
 	j.job.SetInitContainerExecutionHandler(func(exec *kubejob.JobExecutor) error {
@@ -134,7 +266,7 @@ func (j *kubernetesJob) RunWithExecutionHandler(ctx context.Context, handler fun
 	return j.job.RunWithExecutionHandler(ctx, func(execs []*kubejob.JobExecutor) error {
 		converted := make([]JobExecutor, 0, len(execs))
 		for _, exec := range execs {
-			e := &kubernetesJobExecutor{exec: exec}
+			e := &kubernetesJobExecutor{exec: exec, podClient: podClient}
 			if err := j.mountCallback(ctx, e, false); err != nil {
 				return err
 			}
@@ -145,15 +277,60 @@ func (j *kubernetesJob) RunWithExecutionHandler(ctx context.Context, handler fun
 }
 
 type kubernetesJobExecutor struct {
-	exec *kubejob.JobExecutor
+	exec      *kubejob.JobExecutor
+	podClient typedcorev1.PodInterface
+	usage     *ResourceUsage
 }
 
 func (e *kubernetesJobExecutor) PrepareCommand(cmd []string) ([]byte, error) {
 	return e.exec.ExecPrepareCommand([]string{"sh", "-c", strings.Join(cmd, " ")})
 }
 
-func (e *kubernetesJobExecutor) Output(_ context.Context) ([]byte, error) {
-	return e.exec.ExecOnly()
+func (e *kubernetesJobExecutor) Output(ctx context.Context) ([]byte, error) {
+	return e.execWithContext(ctx, e.exec.ExecOnly)
+}
+
+// ExecWithEnv executes the command of the container with additional environment variables.
+// Unlike Output, this can be called multiple times for the same container.
+// kubejob doesn't pass environment variables to the command, so the image must have `env` command to set them.
+func (e *kubernetesJobExecutor) ExecWithEnv(ctx context.Context, env []corev1.EnvVar) ([]byte, error) {
+	cmd := []string{"env"}
+	for _, v := range env {
+		cmd = append(cmd, fmt.Sprintf("%s=%s", v.Name, v.Value))
+	}
+	cmd = append(cmd, e.exec.Container.Command...)
+	cmd = append(cmd, e.exec.Container.Args...)
+	return e.execWithContext(ctx, func() ([]byte, error) {
+		out, err := e.exec.ExecPrepareCommand(cmd)
+		if err != nil {
+			return out, &kubejob.FailedJob{Pod: e.exec.Pod, Reason: err}
+		}
+		return out, nil
+	})
+}
+
+// execWithContext runs exec and returns as soon as ctx is done.
+// The command can't be killed alone in the container, so it keeps running until the container is stopped by Stop.
+func (e *kubernetesJobExecutor) execWithContext(ctx context.Context, exec func() ([]byte, error)) ([]byte, error) {
+	type execResult struct {
+		out   []byte
+		usage *ResourceUsage
+		err   error
+	}
+	done := make(chan execResult, 1)
+	go func() {
+		out, err := exec()
+		out, usage := parseResourceUsage(out)
+		done <- execResult{out: out, usage: usage, err: err}
+	}()
+	select {
+	case result := <-done:
+		e.usage = result.usage
+		return result.out, result.err
+	case <-ctx.Done():
+		e.usage = nil
+		return nil, &kubejob.FailedJob{Pod: e.exec.Pod, Reason: ctx.Err()}
+	}
 }
 
 func (e *kubernetesJobExecutor) ExecAsync(_ context.Context) {
@@ -164,6 +341,7 @@ func (e *kubernetesJobExecutor) TerminationLog(_ context.Context, log string) er
 	return e.exec.TerminationLog(log)
 }
 
+// Stop stops the container. kubejob can't kill the running command alone, so the container can't run commands after that.
 func (e *kubernetesJobExecutor) Stop(_ context.Context) error {
 	return e.exec.Stop()
 }
@@ -195,6 +373,21 @@ func (e *kubernetesJobExecutor) Pod() *corev1.Pod {
 	return e.exec.Pod
 }
 
+func (e *kubernetesJobExecutor) ResourceUsage() *ResourceUsage {
+	return e.usage
+}
+
+func (e *kubernetesJobExecutor) LatestPod(ctx context.Context) (*corev1.Pod, error) {
+	if e.podClient == nil || e.exec.Pod == nil {
+		return e.exec.Pod, nil
+	}
+	pod, err := e.podClient.Get(ctx, e.exec.Pod.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("kubetest: failed to get pod %s: %w", e.exec.Pod.Name, err)
+	}
+	return pod, nil
+}
+
 type localJob struct {
 	rootDir          string
 	preInitContainer corev1.Container
@@ -224,6 +417,8 @@ func (j *localJob) Mount(cb func(context.Context, JobExecutor, bool) error) {
 	j.mountCallback = cb
 }
 
+func (j *localJob) FailOnImagePullBackOff() {}
+
 func (j *localJob) RunWithExecutionHandler(ctx context.Context, handler func([]JobExecutor) error) error {
 	preInitNameToPath := map[string]string{}
 	if j.preInitCallback != nil {
@@ -255,6 +450,10 @@ func (j *localJob) RunWithExecutionHandler(ctx context.Context, handler func([]J
 type localJobExecutor struct {
 	rootDir   string
 	container corev1.Container
+	// running the command being executed. it is killed by Stop.
+	running *exec.Cmd
+	usage   *ResourceUsage
+	mu      sync.Mutex
 }
 
 func (e *localJobExecutor) cmd(cmdarr []string) (*exec.Cmd, error) {
@@ -299,7 +498,45 @@ func (e *localJobExecutor) Output(_ context.Context) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	return cmd.CombinedOutput()
+	return e.combinedOutput(cmd)
+}
+
+// combinedOutput runs the command as the same as exec.Cmd.CombinedOutput, and makes it possible to kill by Stop.
+func (e *localJobExecutor) combinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	setProcessGroup(cmd)
+	e.mu.Lock()
+	if err := cmd.Start(); err != nil {
+		e.mu.Unlock()
+		return nil, err
+	}
+	e.running = cmd
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		e.running = nil
+		e.usage = processResourceUsage(cmd.ProcessState)
+		e.mu.Unlock()
+	}()
+	err := cmd.Wait()
+	return out.Bytes(), err
+}
+
+func (e *localJobExecutor) ExecWithEnv(_ context.Context, env []corev1.EnvVar) ([]byte, error) {
+	cmdarr := append(e.container.Command, e.container.Args...)
+	if len(cmdarr) == 0 {
+		return nil, fmt.Errorf("kubetest: invalid command. command is empty")
+	}
+	cmd, err := e.cmd(cmdarr)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", v.Name, v.Value))
+	}
+	return e.combinedOutput(cmd)
 }
 
 func (e *localJobExecutor) ExecAsync(_ context.Context) {
@@ -321,7 +558,12 @@ func (e *localJobExecutor) TerminationLog(_ context.Context, _ string) error {
 }
 
 func (e *localJobExecutor) Stop(_ context.Context) error {
-	return nil
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.running == nil {
+		return nil
+	}
+	return killProcess(e.running)
 }
 
 func (e *localJobExecutor) CopyFrom(ctx context.Context, src string, dst string) error {
@@ -356,6 +598,16 @@ func (e *localJobExecutor) Pod() *corev1.Pod {
 	return &corev1.Pod{}
 }
 
+func (e *localJobExecutor) LatestPod(_ context.Context) (*corev1.Pod, error) {
+	return nil, nil
+}
+
+func (e *localJobExecutor) ResourceUsage() *ResourceUsage {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.usage
+}
+
 type dryRunJob struct {
 	job *batchv1.Job
 }
@@ -366,6 +618,7 @@ func (j *dryRunJob) Spec() batchv1.JobSpec {
 
 func (j *dryRunJob) PreInit(c TestJobContainer, cb PreInitCallback)         {}
 func (j *dryRunJob) Mount(_ func(context.Context, JobExecutor, bool) error) {}
+func (j *dryRunJob) FailOnImagePullBackOff()                                {}
 
 func (j *dryRunJob) RunWithExecutionHandler(ctx context.Context, handler func([]JobExecutor) error) error {
 	execs := make([]JobExecutor, 0, len(j.job.Spec.Template.Spec.Containers))
@@ -389,6 +642,10 @@ func (e *dryRunJobExecutor) Output(_ context.Context) ([]byte, error) {
 	return []byte("( dry running .... )"), nil
 }
 
+func (e *dryRunJobExecutor) ExecWithEnv(_ context.Context, _ []corev1.EnvVar) ([]byte, error) {
+	return []byte("( dry running .... )"), nil
+}
+
 func (e *dryRunJobExecutor) ExecAsync(_ context.Context)                      {}
 func (e *dryRunJobExecutor) TerminationLog(_ context.Context, _ string) error { return nil }
 func (e *dryRunJobExecutor) Stop(_ context.Context) error                     { return nil }
@@ -409,3 +666,11 @@ func (e *dryRunJobExecutor) Container() corev1.Container {
 func (e *dryRunJobExecutor) Pod() *corev1.Pod {
 	return &corev1.Pod{}
 }
+
+func (e *dryRunJobExecutor) LatestPod(_ context.Context) (*corev1.Pod, error) {
+	return nil, nil
+}
+
+func (e *dryRunJobExecutor) ResourceUsage() *ResourceUsage {
+	return nil
+}
@@ -0,0 +1,33 @@
+//go:build !ignore_autogenerated && !windows
+// +build !ignore_autogenerated,!windows
+
+package v1
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// setProcessGroup runs the command in the new process group so that killProcess can kill its child processes too.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killProcess(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// peakMemoryBytes returns the maximum resident set size of the exited process.
+func peakMemoryBytes(state *os.ProcessState) int64 {
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0
+	}
+	// ru_maxrss is reported in bytes on darwin and in kilobytes on the others.
+	if runtime.GOOS == "darwin" {
+		return int64(rusage.Maxrss)
+	}
+	return int64(rusage.Maxrss) * 1024
+}
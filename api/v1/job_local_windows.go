@@ -0,0 +1,20 @@
+//go:build !ignore_autogenerated && windows
+// +build !ignore_autogenerated,windows
+
+package v1
+
+import (
+	"os"
+	"os/exec"
+)
+
+func setProcessGroup(_ *exec.Cmd) {}
+
+func killProcess(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// peakMemoryBytes isn't supported because rusage isn't available.
+func peakMemoryBytes(_ *os.ProcessState) int64 {
+	return 0
+}
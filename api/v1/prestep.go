@@ -0,0 +1,200 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// isPreStepDAG reports whether preSteps run as DAG.
+// They run as DAG if one of them specifies dependsOn or more than one preStep can run at the same time by maxConcurrentNum.
+func isPreStepDAG(steps []PreStep, maxConcurrentNum int) bool {
+	if maxConcurrentNum > 1 {
+		return true
+	}
+	for _, step := range steps {
+		if len(step.DependsOn) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// preStepDependencies returns the names of preSteps that each preStep depends on.
+// If preSteps run as DAG, the preStep without dependsOn is the root of DAG that starts immediately.
+// Otherwise, each preStep depends on the previous one to keep the order of the list.
+func preStepDependencies(steps []PreStep, dag bool) map[string][]string {
+	deps := make(map[string][]string, len(steps))
+	if dag {
+		for _, step := range steps {
+			deps[step.Name] = step.DependsOn
+		}
+		return deps
+	}
+	for idx, step := range steps {
+		if idx == 0 {
+			deps[step.Name] = nil
+			continue
+		}
+		deps[step.Name] = []string{steps[idx-1].Name}
+	}
+	return deps
+}
+
+// sortPreSteps sorts preSteps so that every preStep comes after its dependencies.
+// The independent preSteps keep the order of the list. Returns error if the dependencies have unknown name or cycle.
+func sortPreSteps(steps []PreStep, dag bool) ([]PreStep, error) {
+	deps := preStepDependencies(steps, dag)
+	nameToStep := make(map[string]PreStep, len(steps))
+	for _, step := range steps {
+		nameToStep[step.Name] = step
+	}
+	for _, step := range steps {
+		for _, dep := range deps[step.Name] {
+			if _, exists := nameToStep[dep]; !exists {
+				return nil, fmt.Errorf("kubetest: prestep %s depends on unknown prestep %s", step.Name, dep)
+			}
+		}
+	}
+	sorted := make([]PreStep, 0, len(steps))
+	sortedNames := map[string]struct{}{}
+	for len(sorted) < len(steps) {
+		found := false
+		for _, step := range steps {
+			if _, exists := sortedNames[step.Name]; exists {
+				continue
+			}
+			ready := true
+			for _, dep := range deps[step.Name] {
+				if _, exists := sortedNames[dep]; !exists {
+					ready = false
+					break
+				}
+			}
+			if !ready {
+				continue
+			}
+			sorted = append(sorted, step)
+			sortedNames[step.Name] = struct{}{}
+			found = true
+		}
+		if !found {
+			cycle := []string{}
+			for _, step := range steps {
+				if _, exists := sortedNames[step.Name]; !exists {
+					cycle = append(cycle, step.Name)
+				}
+			}
+			return nil, fmt.Errorf("kubetest: dependsOn of presteps has cycle: %s", strings.Join(cycle, ", "))
+		}
+	}
+	return sorted, nil
+}
+
+// preStepAncestors returns the names of preSteps that the preStep depends on directly or indirectly.
+func preStepAncestors(name string, deps map[string][]string) map[string]struct{} {
+	ancestors := map[string]struct{}{}
+	var walk func(string)
+	walk = func(name string) {
+		for _, dep := range deps[name] {
+			if _, exists := ancestors[dep]; exists {
+				continue
+			}
+			ancestors[dep] = struct{}{}
+			walk(dep)
+		}
+	}
+	walk(name)
+	return ancestors
+}
+
+// runPreSteps runs preSteps as DAG by dependsOn. The preStep starts as soon as all of its dependencies finish,
+// and the independent preSteps run in parallel up to maxConcurrentNum ( unlimited if zero ).
+// If no preStep specifies dependsOn and maxConcurrentNum isn't greater than 1, they run in order of the list.
+// If one of preSteps fails, the running preSteps are stopped and the error is returned.
+func (r *Runner) runPreSteps(ctx context.Context, builder *TaskBuilder, steps []PreStep, maxConcurrentNum int) ([]*TaskResult, error) {
+	if len(steps) == 0 {
+		return nil, nil
+	}
+	deps := preStepDependencies(steps, isPreStepDAG(steps, maxConcurrentNum))
+	done := make(map[string]chan struct{}, len(steps))
+	for _, step := range steps {
+		done[step.Name] = make(chan struct{})
+	}
+	if maxConcurrentNum <= 0 {
+		maxConcurrentNum = len(steps)
+	}
+	sem := make(chan struct{}, maxConcurrentNum)
+	results := make([][]*TaskResult, len(steps))
+	eg, egCtx := errgroup.WithContext(ctx)
+	for idx, step := range steps {
+		idx, step := idx, step
+		eg.Go(func() error {
+			defer close(done[step.Name])
+			for _, dep := range deps[step.Name] {
+				select {
+				case <-done[dep]:
+				case <-egCtx.Done():
+				}
+			}
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-egCtx.Done():
+			}
+			if ctx.Err() != nil {
+				r.logger.Warn("skip prestep %s because the deadline is reached", step.Name)
+				return nil
+			}
+			if egCtx.Err() != nil {
+				// the other preStep failed.
+				return nil
+			}
+			var cacheKey string
+			if step.Cache != nil && r.runMode != RunModeDryRun {
+				key, found, err := r.restorePreStepCache(egCtx, builder, step)
+				if err != nil {
+					return fmt.Errorf("kubetest: failed to restore cache of prestep %s: %w", step.Name, err)
+				}
+				if found {
+					r.logger.Info("skip prestep %s because the cache %s is found", step.Name, key)
+					return nil
+				}
+				r.logger.Info("cache %s of prestep %s isn't found", key, step.Name)
+				cacheKey = key
+			}
+			r.logger.Info("run prestep: %s", step.Name)
+			stepResults, err := r.runStep(egCtx, builder, &step)
+			if errors.Is(err, errDeadlineReached) {
+				// the result of the stopped preStep isn't used. the following steps are skipped by the deadline.
+				r.logger.Warn("prestep %s is stopped because the deadline is reached", step.Name)
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("kubetest: failed to run prestep %s: %w", step.Name, err)
+			}
+			results[idx] = stepResults
+			if cacheKey != "" {
+				// the failure of saving cache doesn't fail the test because the cache is used only to skip the step.
+				if err := r.savePreStepCache(egCtx, builder, step, cacheKey); err != nil {
+					r.logger.Warn("failed to save cache of prestep %s: %s", step.Name, err.Error())
+				}
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	finished := make([]*TaskResult, 0, len(results))
+	for _, stepResults := range results {
+		finished = append(finished, stepResults...)
+	}
+	return finished, nil
+}
@@ -0,0 +1,162 @@
+package v1
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPreStepDependencies(t *testing.T) {
+	preStep := func(name string, dependsOn ...string) PreStep {
+		return PreStep{Name: name, DependsOn: dependsOn}
+	}
+	names := func(steps []PreStep) string {
+		names := make([]string, 0, len(steps))
+		for _, step := range steps {
+			names = append(names, step.Name)
+		}
+		return fmt.Sprint(names)
+	}
+	t.Run("SortPreSteps", func(t *testing.T) {
+		for _, test := range []struct {
+			name     string
+			steps    []PreStep
+			expected string
+		}{
+			{name: "sequential without dependsOn", steps: []PreStep{preStep("a"), preStep("b"), preStep("c")}, expected: "[a b c]"},
+			{name: "dag", steps: []PreStep{preStep("test", "build", "download"), preStep("build"), preStep("download")}, expected: "[build download test]"},
+			{name: "independent", steps: []PreStep{preStep("b"), preStep("a", "b"), preStep("c")}, expected: "[b a c]"},
+		} {
+			sorted, err := sortPreSteps(test.steps, isPreStepDAG(test.steps, 0))
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			if names(sorted) != test.expected {
+				t.Fatalf("%s: failed to sort presteps: expected %s but got %s", test.name, test.expected, names(sorted))
+			}
+		}
+		if _, err := sortPreSteps([]PreStep{preStep("a", "b"), preStep("b", "c"), preStep("c", "a")}, true); err == nil {
+			t.Fatal("expected error for cycle")
+		}
+		if _, err := sortPreSteps([]PreStep{preStep("a", "unknown")}, true); err == nil {
+			t.Fatal("expected error for unknown prestep")
+		}
+	})
+	t.Run("PreStepDependencies", func(t *testing.T) {
+		for _, test := range []struct {
+			name             string
+			steps            []PreStep
+			maxConcurrentNum int
+			expected         string
+		}{
+			{name: "sequential", steps: []PreStep{preStep("a"), preStep("b"), preStep("c")}, expected: "map[a:[] b:[a] c:[b]]"},
+			{name: "sequential by one concurrency", steps: []PreStep{preStep("a"), preStep("b")}, maxConcurrentNum: 1, expected: "map[a:[] b:[a]]"},
+			{name: "independent by concurrency", steps: []PreStep{preStep("a"), preStep("b")}, maxConcurrentNum: 2, expected: "map[a:[] b:[]]"},
+			{name: "standalone with dependsOn", steps: []PreStep{preStep("a"), preStep("b", "a"), preStep("c")}, expected: "map[a:[] b:[a] c:[]]"},
+		} {
+			deps := preStepDependencies(test.steps, isPreStepDAG(test.steps, test.maxConcurrentNum))
+			if fmt.Sprint(deps) != test.expected {
+				t.Fatalf("%s: failed to get dependencies: expected %s but got %v", test.name, test.expected, deps)
+			}
+			if err := NewValidator().ValidatePreStepDependencies(test.steps, test.maxConcurrentNum); err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+		}
+	})
+	t.Run("PreStepAncestors", func(t *testing.T) {
+		deps := preStepDependencies([]PreStep{preStep("a"), preStep("b", "a"), preStep("c", "b"), preStep("d")}, true)
+		ancestors := preStepAncestors("c", deps)
+		if _, exists := ancestors["a"]; !exists || len(ancestors) != 2 {
+			t.Fatalf("failed to get ancestors: %v", ancestors)
+		}
+	})
+	t.Run("ValidateArtifactVisibility", func(t *testing.T) {
+		artifactStep := func(name string, dependsOn ...string) PreStep {
+			step := preStep(name, dependsOn...)
+			step.Template.Spec.Artifacts = []ArtifactSpec{{Name: name + "-artifact"}}
+			return step
+		}
+		useArtifact := func(step PreStep, artifact string) PreStep {
+			step.Template.Spec.Volumes = []TestJobVolume{{
+				Name:                artifact,
+				TestJobVolumeSource: TestJobVolumeSource{Artifact: &ArtifactVolumeSource{Name: artifact}},
+			}}
+			return step
+		}
+		for _, test := range []struct {
+			name  string
+			steps []PreStep
+			valid bool
+		}{
+			{
+				name:  "use artifact of dependency",
+				steps: []PreStep{artifactStep("build"), useArtifact(preStep("test", "build"), "build-artifact")},
+				valid: true,
+			},
+			{
+				name:  "use artifact of indirect dependency",
+				steps: []PreStep{artifactStep("build"), preStep("download", "build"), useArtifact(preStep("test", "download"), "build-artifact")},
+				valid: true,
+			},
+			{
+				name:  "use artifact of independent prestep",
+				steps: []PreStep{artifactStep("build"), artifactStep("download"), useArtifact(preStep("test", "build"), "download-artifact")},
+			},
+			{
+				name:  "use artifact of previous prestep without dependsOn",
+				steps: []PreStep{artifactStep("build"), useArtifact(preStep("test"), "build-artifact")},
+				valid: true,
+			},
+		} {
+			v := NewValidator()
+			if err := v.ValidatePreStepDependencies(test.steps, 0); err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			dag := isPreStepDAG(test.steps, 0)
+			sorted, err := sortPreSteps(test.steps, dag)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			deps := preStepDependencies(test.steps, dag)
+			var validateErr error
+			for _, step := range sorted {
+				if validateErr = v.ValidatePreStepArtifactVolumes(step, preStepAncestors(step.Name, deps)); validateErr != nil {
+					break
+				}
+				for _, artifact := range step.Template.Spec.Artifacts {
+					v.artifactToPreStep[artifact.Name] = step.Name
+				}
+			}
+			if test.valid != (validateErr == nil) {
+				t.Fatalf("%s: unexpected validation result: %v", test.name, validateErr)
+			}
+		}
+	})
+	t.Run("ValidatePreStepDependencies", func(t *testing.T) {
+		for _, steps := range [][]PreStep{
+			{preStep("a"), preStep("a")},
+			{preStep("a", "a")},
+			{preStep("a", "b"), preStep("b", "a")},
+		} {
+			if err := NewValidator().ValidatePreStepDependencies(steps, 0); err == nil {
+				t.Fatalf("expected error for %v", steps)
+			}
+		}
+	})
+	t.Run("ValidateStepStrategy", func(t *testing.T) {
+		key := StrategyKeySpec{Env: "TEST", Source: StrategyKeySource{Static: []string{"A"}}}
+		scheduler := Scheduler{MaxContainersPerPod: 1, MaxConcurrentNumPerPod: 1}
+		if err := NewValidator().ValidateStepStrategy(&Strategy{Key: key, Scheduler: scheduler}, PreStepType); err != nil {
+			t.Fatal(err)
+		}
+		for _, strategy := range []*Strategy{
+			{Key: key, Scheduler: scheduler, Retest: true},
+			{Key: key, Scheduler: scheduler, FlakyDetection: &StrategyFlakyDetection{}},
+			{Key: key, Scheduler: scheduler, Quarantine: &StrategyQuarantine{}},
+			{Key: key, Scheduler: scheduler, Repeat: 2},
+		} {
+			if err := NewValidator().ValidateStepStrategy(strategy, PreStepType); err == nil {
+				t.Fatalf("expected error for %+v", strategy)
+			}
+		}
+	})
+}
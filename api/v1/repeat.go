@@ -0,0 +1,116 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// repeatKeys repeats the whole keys so that the runs of the same key are spread across containers.
+func repeatKeys(keys []string, repeat int) []string {
+	if repeat <= 1 {
+		return keys
+	}
+	repeated := make([]string, 0, len(keys)*repeat)
+	for i := 0; i < repeat; i++ {
+		repeated = append(repeated, keys...)
+	}
+	return repeated
+}
+
+// mergeRepeatedResults merges the results of the same key run repeatedly into one result.
+// If one of the runs failed, the failed result is kept to show the cause. The elapsed time of the kept result is the median of the runs.
+func (g *TaskResultGroup) mergeRepeatedResults(repeat int) {
+	if repeat <= 1 {
+		return
+	}
+	nameToResults := map[string][]*SubTaskResult{}
+	for _, result := range g.results {
+		for _, group := range result.groups {
+			for _, subTaskResult := range group.results {
+				nameToResults[subTaskResult.Name] = append(nameToResults[subTaskResult.Name], subTaskResult)
+			}
+		}
+	}
+	merged := make(map[*SubTaskResult]struct{}, len(nameToResults))
+	for _, results := range nameToResults {
+		mergedResult := representativeResult(results)
+		elapsedTimes := []time.Duration{}
+		for _, result := range results {
+			if result.Status == TaskResultSkipped {
+				continue
+			}
+			elapsedTimes = append(elapsedTimes, result.ElapsedTime)
+		}
+		mergedResult.RepeatedElapsedTimes = elapsedTimes
+		if stats := newElapsedTimeStats(elapsedTimes); stats != nil {
+			mergedResult.ElapsedTime = time.Duration(stats.MedianMsec) * time.Millisecond
+		}
+		merged[mergedResult] = struct{}{}
+	}
+	for _, result := range g.results {
+		for _, group := range result.groups {
+			filtered := make([]*SubTaskResult, 0, len(group.results))
+			for _, subTaskResult := range group.results {
+				if _, exists := merged[subTaskResult]; exists {
+					filtered = append(filtered, subTaskResult)
+				}
+			}
+			group.results = filtered
+		}
+	}
+	g.totalSubTaskNum = len(nameToResults)
+}
+
+func representativeResult(results []*SubTaskResult) *SubTaskResult {
+	for _, result := range results {
+		if result.Status.failed() {
+			return result
+		}
+	}
+	for _, result := range results {
+		if result.Status == TaskResultSuccess {
+			return result
+		}
+	}
+	return results[0]
+}
+
+// newElapsedTimeStats returns the statistics of the elapsed times.
+// p95 is calculated by the nearest-rank method. If elapsedTimes is empty, returns nil.
+func newElapsedTimeStats(elapsedTimes []time.Duration) *ReportElapsedTimeStats {
+	if len(elapsedTimes) == 0 {
+		return nil
+	}
+	sorted := make([]time.Duration, len(elapsedTimes))
+	copy(sorted, elapsedTimes)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	n := len(sorted)
+	median := sorted[n/2]
+	if n%2 == 0 {
+		median = (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	p95 := sorted[int(math.Ceil(float64(n)*0.95))-1]
+	var sum float64
+	for _, elapsedTime := range sorted {
+		sum += float64(elapsedTime)
+	}
+	mean := sum / float64(n)
+	var variance float64
+	for _, elapsedTime := range sorted {
+		variance += (float64(elapsedTime) - mean) * (float64(elapsedTime) - mean)
+	}
+	stddev := time.Duration(math.Sqrt(variance / float64(n)))
+	return &ReportElapsedTimeStats{
+		Runs:       n,
+		MinMsec:    sorted[0].Milliseconds(),
+		MedianMsec: median.Milliseconds(),
+		P95Msec:    p95.Milliseconds(),
+		StddevMsec: stddev.Milliseconds(),
+	}
+}
@@ -0,0 +1,87 @@
+package v1
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRepeat(t *testing.T) {
+	t.Run("RepeatKeys", func(t *testing.T) {
+		if keys := repeatKeys([]string{"A", "B"}, 3); fmt.Sprint(keys) != "[A B A B A B]" {
+			t.Fatalf("failed to repeat keys: %v", keys)
+		}
+		if keys := repeatKeys([]string{"A", "B"}, 0); fmt.Sprint(keys) != "[A B]" {
+			t.Fatalf("keys must not be repeated: %v", keys)
+		}
+	})
+	t.Run("ValidateStrategy", func(t *testing.T) {
+		strategy := func(repeat, keysPerContainer int) *Strategy {
+			return &Strategy{
+				Key:       StrategyKeySpec{Env: "TEST", Source: StrategyKeySource{Static: []string{"A", "B"}}},
+				Scheduler: Scheduler{MaxContainersPerPod: 2, MaxConcurrentNumPerPod: 2, KeysPerContainer: keysPerContainer},
+				Repeat:    repeat,
+			}
+		}
+		if err := NewValidator().ValidateStrategy(strategy(3, 0)); err != nil {
+			t.Fatal(err)
+		}
+		if err := NewValidator().ValidateStrategy(strategy(3, 2)); err == nil {
+			t.Fatal("expected error for repeat with keysPerContainer")
+		}
+	})
+	t.Run("ElapsedTimeStats", func(t *testing.T) {
+		elapsedTimes := []time.Duration{}
+		for _, msec := range []int64{500, 100, 300, 200, 400, 1000, 600, 700, 800, 900} {
+			elapsedTimes = append(elapsedTimes, time.Duration(msec)*time.Millisecond)
+		}
+		stats := newElapsedTimeStats(elapsedTimes)
+		expected := ReportElapsedTimeStats{Runs: 10, MinMsec: 100, MedianMsec: 550, P95Msec: 1000, StddevMsec: 287}
+		if stats == nil || *stats != expected {
+			t.Fatalf("unexpected stats: expected %+v but got %+v", expected, stats)
+		}
+		if newElapsedTimeStats(nil) != nil {
+			t.Fatal("expected no stats for empty elapsed times")
+		}
+	})
+	t.Run("MergeRepeatedResults", func(t *testing.T) {
+		result := func(name string, status TaskResultStatus, msec int64) *SubTaskResult {
+			r := &SubTaskResult{Name: name, KeyEnvName: "TEST", Status: status, ElapsedTime: time.Duration(msec) * time.Millisecond}
+			if status == TaskResultFailure {
+				r.Err = errors.New("error")
+			}
+			return r
+		}
+		rg := &TaskResultGroup{totalSubTaskNum: 9}
+		rg.add(&TaskResult{groups: []*SubTaskResultGroup{{results: []*SubTaskResult{
+			result("A", TaskResultSuccess, 100),
+			result("B", TaskResultSuccess, 1000),
+			result("A", TaskResultSuccess, 300),
+			result("C", TaskResultSuccess, 100),
+		}}}})
+		rg.add(&TaskResult{groups: []*SubTaskResultGroup{{results: []*SubTaskResult{
+			result("B", TaskResultFailure, 3000),
+			result("A", TaskResultSuccess, 200),
+			result("B", TaskResultSkipped, 0),
+			result("C", TaskResultSuccess, 100),
+			result("C", TaskResultSuccess, 100),
+		}}}})
+		rg.mergeRepeatedResults(3)
+		if rg.TotalNum() != 3 || rg.SuccessNum() != 2 || rg.FailureNum() != 1 {
+			t.Fatalf("failed to merge repeated results: total %d success %d failure %d", rg.TotalNum(), rg.SuccessNum(), rg.FailureNum())
+		}
+		for _, detail := range rg.ToReportDetails() {
+			switch detail.Name {
+			case "A":
+				if detail.ElapsedTimeMsec != 200 || detail.ElapsedTimeStats == nil || detail.ElapsedTimeStats.Runs != 3 {
+					t.Fatalf("unexpected detail of A: %+v", detail)
+				}
+			case "B":
+				if detail.Status != ResultStatusFailure || detail.ElapsedTimeMsec != 2000 || detail.ElapsedTimeStats == nil || detail.ElapsedTimeStats.Runs != 2 {
+					t.Fatalf("unexpected detail of B: %+v", detail)
+				}
+			}
+		}
+	})
+}
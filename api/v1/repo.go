@@ -25,6 +25,8 @@ type RepositoryManager struct {
 	tokenMgr     *TokenManager
 	clonedPaths  map[string]string
 	archivePaths map[string]string
+	// changedFiles changed files between the merge base and HEAD for each merged repository.
+	changedFiles map[string][]string
 }
 
 func NewRepositoryManager(repos []RepositorySpec, tokenMgr *TokenManager) *RepositoryManager {
@@ -33,6 +35,7 @@ func NewRepositoryManager(repos []RepositorySpec, tokenMgr *TokenManager) *Repos
 		tokenMgr:     tokenMgr,
 		clonedPaths:  map[string]string{},
 		archivePaths: map[string]string{},
+		changedFiles: map[string][]string{},
 	}
 }
 
@@ -60,7 +63,7 @@ func (m *RepositoryManager) CloneAll(ctx context.Context) error {
 		if repo.Value.ClonedPath != "" {
 			dir := repo.Value.ClonedPath
 			if !existsDir(dir) {
-				if err := m.clone(ctx, dir, repo.Value); err != nil {
+				if err := m.clone(ctx, dir, repo); err != nil {
 					return err
 				}
 			} else {
@@ -72,7 +75,7 @@ func (m *RepositoryManager) CloneAll(ctx context.Context) error {
 			if err != nil {
 				return fmt.Errorf("kubetest: failed to create temporary directory for repository: %w", err)
 			}
-			if err := m.clone(ctx, dir, repo.Value); err != nil {
+			if err := m.clone(ctx, dir, repo); err != nil {
 				return err
 			}
 			repoDir = dir
@@ -91,7 +94,8 @@ func (m *RepositoryManager) CloneAll(ctx context.Context) error {
 	return nil
 }
 
-func (m *RepositoryManager) clone(ctx context.Context, clonedPath string, repo Repository) error {
+func (m *RepositoryManager) clone(ctx context.Context, clonedPath string, spec RepositorySpec) error {
+	repo := spec.Value
 	LoggerFromContext(ctx).Info("clone repository: %s", repo.URL)
 
 	const (
@@ -179,6 +183,10 @@ func (m *RepositoryManager) clone(ctx context.Context, clonedPath string, repo R
 				return fmt.Errorf("kubetest: failed to set git config: user.name and user.email: %w", err)
 			}
 		}
+		head, err := gitOutput(clonedPath, "rev-parse", "HEAD")
+		if err != nil {
+			return err
+		}
 		// we'd like to use '--ff' strategy ( merge's default behavior ).
 		// go-git doesn't support yet, so we use git client command.
 		LoggerFromContext(ctx).Info("merge base branch: git pull %s %s", remote, baseBranch)
@@ -189,10 +197,46 @@ func (m *RepositoryManager) clone(ctx context.Context, clonedPath string, repo R
 			return fmt.Errorf("kubetest: failed to merge base branch %s: %w", string(out), err)
 		}
 		LoggerFromContext(ctx).Debug(string(out))
+		changedFiles, err := m.changedFilesFromMergeBase(clonedPath, head)
+		if err != nil {
+			return err
+		}
+		LoggerFromContext(ctx).Debug("found %d changed files from base branch %s", len(changedFiles), baseBranch)
+		m.changedFiles[spec.Name] = changedFiles
 	}
 	return nil
 }
 
+// changedFilesFromMergeBase returns the changed files between the merge base of fetched base branch and head.
+func (m *RepositoryManager) changedFilesFromMergeBase(clonedPath, head string) ([]string, error) {
+	mergeBase, err := gitOutput(clonedPath, "merge-base", head, "FETCH_HEAD")
+	if err != nil {
+		return nil, err
+	}
+	out, err := gitOutput(clonedPath, "diff", "--name-only", mergeBase, head)
+	if err != nil {
+		return nil, err
+	}
+	changedFiles := []string{}
+	for _, file := range strings.Split(out, "\n") {
+		if file == "" {
+			continue
+		}
+		changedFiles = append(changedFiles, file)
+	}
+	return changedFiles, nil
+}
+
+func gitOutput(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("kubetest: failed to run git %s: %s: %w", strings.Join(args, " "), string(out), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
 func (m *RepositoryManager) archiveRepo(repoDir, archivePath string) error {
 	dst, err := os.Create(archivePath)
 	if err != nil {
@@ -237,6 +281,32 @@ func (m *RepositoryManager) archiveRepo(repoDir, archivePath string) error {
 	})
 }
 
+// ChangedFilesByRepoName returns the changed files between the merge base and HEAD of the merged repository.
+func (m *RepositoryManager) ChangedFilesByRepoName(name string) ([]string, error) {
+	files, exists := m.changedFiles[name]
+	if !exists {
+		return nil, fmt.Errorf("kubetest: changed files of repository %s are unknown. the repository must be cloned and merged by kubetest", name)
+	}
+	return files, nil
+}
+
+// CommitHashByRepoName returns the commit hash of HEAD of the cloned repository.
+func (m *RepositoryManager) CommitHashByRepoName(name string) (string, error) {
+	clonedPath, err := m.ClonedPathByRepoName(name)
+	if err != nil {
+		return "", err
+	}
+	return gitOutput(clonedPath, "rev-parse", "HEAD")
+}
+
+func (m *RepositoryManager) ClonedPathByRepoName(name string) (string, error) {
+	path, exists := m.clonedPaths[name]
+	if !exists {
+		return "", fmt.Errorf("kubetest: repository name %s is undefined", name)
+	}
+	return path, nil
+}
+
 func (m *RepositoryManager) ArchivePathByRepoName(name string) (string, error) {
 	path, exists := m.archivePaths[name]
 	if !exists {
@@ -0,0 +1,133 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LoadReport loads the report of kubetest written in JSON format.
+func LoadReport(path string) (*Report, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("kubetest: failed to read report from %s: %w", path, err)
+	}
+	var report Report
+	if err := json.Unmarshal(file, &report); err != nil {
+		return nil, fmt.Errorf("kubetest: failed to decode report %s: %w", path, err)
+	}
+	return &report, nil
+}
+
+// ElapsedTimeMsecByName returns a map of the detail name to its elapsed time in milliseconds.
+// The report written by the older version doesn't have elapsedTimeMsec, so elapsedTimeSec is used instead.
+// If the same name exists multiple times, the longest elapsed time is used.
+func (r *Report) ElapsedTimeMsecByName() map[string]int64 {
+	nameToElapsedTime := map[string]int64{}
+	for _, detail := range r.Details {
+		elapsedTimeMsec := detail.ElapsedTimeMsec
+		if elapsedTimeMsec == 0 {
+			elapsedTimeMsec = detail.ElapsedTimeSec * 1000
+		}
+		if elapsedTime, exists := nameToElapsedTime[detail.Name]; exists && elapsedTime >= elapsedTimeMsec {
+			continue
+		}
+		nameToElapsedTime[detail.Name] = elapsedTimeMsec
+	}
+	return nameToElapsedTime
+}
+
+// MergeReports merges the reports of multiple kubetest invocations ( e.g. each shard ) into one report.
+// If the reports have shard, all shards must be included.
+func MergeReports(reports []*Report) (*Report, error) {
+	if len(reports) == 0 {
+		return nil, fmt.Errorf("kubetest: reports to merge are empty")
+	}
+	if err := validateShardReports(reports); err != nil {
+		return nil, err
+	}
+	merged := &Report{
+		Status:  ResultStatusSuccess,
+		Details: []*ReportDetail{},
+	}
+	var startedAt, finishedAt time.Time
+	for idx, report := range reports {
+		merged.Status = mergeResultStatus(merged.Status, report.Status)
+		merged.TotalNum += report.TotalNum
+		merged.SuccessNum += report.SuccessNum
+		merged.FailureNum += report.FailureNum
+		merged.UnknownNum += report.UnknownNum
+		merged.SkippedNum += report.SkippedNum
+		merged.TimeoutNum += report.TimeoutNum
+		merged.RetryNum += report.RetryNum
+		merged.QuarantinedFailureNum += report.QuarantinedFailureNum
+		merged.FlakyNum += report.FlakyNum
+		merged.BrokenNum += report.BrokenNum
+		merged.Details = append(merged.Details, report.Details...)
+		for k, v := range report.ExtParam {
+			if merged.ExtParam == nil {
+				merged.ExtParam = map[string]string{}
+			}
+			merged.ExtParam[k] = v
+		}
+		reportStartedAt := report.StartedAt.Time
+		reportFinishedAt := reportStartedAt.Add(time.Duration(report.ElapsedTimeSec) * time.Second)
+		if idx == 0 || reportStartedAt.Before(startedAt) {
+			startedAt = reportStartedAt
+		}
+		if reportFinishedAt.After(finishedAt) {
+			finishedAt = reportFinishedAt
+		}
+	}
+	merged.StartedAt = metav1.NewTime(startedAt)
+	merged.ElapsedTimeSec = int64(finishedAt.Sub(startedAt).Seconds())
+	return merged, nil
+}
+
+func validateShardReports(reports []*Report) error {
+	if reports[0].Shard == nil {
+		for _, report := range reports {
+			if report.Shard != nil {
+				return fmt.Errorf("kubetest: failed to merge the report of shard and the report that isn't sharded")
+			}
+		}
+		return nil
+	}
+	total := reports[0].Shard.Total
+	indexes := map[int]struct{}{}
+	for _, report := range reports {
+		if report.Shard == nil {
+			return fmt.Errorf("kubetest: failed to merge the report of shard and the report that isn't sharded")
+		}
+		if report.Shard.Total != total {
+			return fmt.Errorf("kubetest: shard total mismatch: %d and %d", total, report.Shard.Total)
+		}
+		if _, exists := indexes[report.Shard.Index]; exists {
+			return fmt.Errorf("kubetest: shard %d/%d is duplicated", report.Shard.Index, total)
+		}
+		indexes[report.Shard.Index] = struct{}{}
+	}
+	for idx := 0; idx < total; idx++ {
+		if _, exists := indexes[idx]; !exists {
+			return fmt.Errorf("kubetest: report of shard %d/%d is missing", idx, total)
+		}
+	}
+	return nil
+}
+
+// mergeResultStatus returns the worse status. error is worse than failure.
+func mergeResultStatus(a, b ResultStatus) ResultStatus {
+	switch {
+	case a == ResultStatusError || b == ResultStatusError:
+		return ResultStatusError
+	case a == ResultStatusFailure || b == ResultStatusFailure:
+		return ResultStatusFailure
+	}
+	return ResultStatusSuccess
+}
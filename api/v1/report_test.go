@@ -0,0 +1,86 @@
+package v1
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMergeReports(t *testing.T) {
+	startedAt := time.Now()
+	shardReports := func() []*Report {
+		return []*Report{
+			{
+				Status:         ResultStatusSuccess,
+				StartedAt:      metav1.NewTime(startedAt),
+				ElapsedTimeSec: 10,
+				TotalNum:       1,
+				SuccessNum:     1,
+				RetryNum:       1,
+				Details:        []*ReportDetail{{Status: ResultStatusSuccess, Name: "A"}},
+				Shard:          &Shard{Index: 0, Total: 2},
+			},
+			{
+				Status:         ResultStatusFailure,
+				StartedAt:      metav1.NewTime(startedAt.Add(5 * time.Second)),
+				ElapsedTimeSec: 10,
+				TotalNum:       2,
+				SuccessNum:     1,
+				FailureNum:     1,
+				RetryNum:       2,
+				Details: []*ReportDetail{
+					{Status: ResultStatusSuccess, Name: "B"},
+					{Status: ResultStatusFailure, Name: "C"},
+				},
+				Shard: &Shard{Index: 1, Total: 2},
+			},
+		}
+	}
+	t.Run("merge all shards", func(t *testing.T) {
+		report, err := MergeReports(shardReports())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Status != ResultStatusFailure {
+			t.Fatalf("failed to merge status: %s", report.Status)
+		}
+		if report.TotalNum != 3 || report.SuccessNum != 2 || report.FailureNum != 1 || report.RetryNum != 3 {
+			t.Fatalf("failed to merge num: %+v", report)
+		}
+		if len(report.Details) != 3 {
+			t.Fatalf("failed to merge details: %d", len(report.Details))
+		}
+		if report.ElapsedTimeSec != 15 {
+			t.Fatalf("failed to merge elapsed time: expected 15 but got %d", report.ElapsedTimeSec)
+		}
+	})
+	t.Run("missing shard", func(t *testing.T) {
+		if _, err := MergeReports(shardReports()[:1]); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+	t.Run("duplicated shard", func(t *testing.T) {
+		reports := shardReports()
+		reports[1].Shard.Index = 0
+		if _, err := MergeReports(reports); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestElapsedTimeMsecByName(t *testing.T) {
+	report := &Report{
+		Details: []*ReportDetail{
+			{Name: "A", ElapsedTimeSec: 0, ElapsedTimeMsec: 300},
+			{Name: "B", ElapsedTimeSec: 0, ElapsedTimeMsec: 800},
+			{Name: "B", ElapsedTimeSec: 0, ElapsedTimeMsec: 600},
+			// the report written by the older version doesn't have elapsedTimeMsec.
+			{Name: "C", ElapsedTimeSec: 2},
+		},
+	}
+	nameToElapsedTime := report.ElapsedTimeMsecByName()
+	if nameToElapsedTime["A"] != 300 || nameToElapsedTime["B"] != 800 || nameToElapsedTime["C"] != 2000 {
+		t.Fatalf("failed to get elapsed time by name: %v", nameToElapsedTime)
+	}
+}
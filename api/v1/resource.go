@@ -11,10 +11,13 @@ import (
 	"path/filepath"
 	"sync"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
 type ResourceManager struct {
+	clientset   *kubernetes.Clientset
+	namespace   string
 	repoMgr     *RepositoryManager
 	tokenMgr    *TokenManager
 	artifactMgr *ArtifactManager
@@ -30,6 +33,8 @@ func NewResourceManager(clientset *kubernetes.Clientset, testjob TestJob) *Resou
 	repoMgr := NewRepositoryManager(testjob.Spec.Repos, tokenMgr)
 	artifactMgr := NewArtifactManager(testjob.Spec.ExportArtifacts)
 	return &ResourceManager{
+		clientset:   clientset,
+		namespace:   testjob.Namespace,
 		repoMgr:     repoMgr,
 		tokenMgr:    tokenMgr,
 		artifactMgr: artifactMgr,
@@ -120,6 +125,49 @@ func (m *ResourceManager) RepositoryPathByName(name string) (string, error) {
 	return m.repoMgr.ArchivePathByRepoName(name)
 }
 
+// RepositoryFilePathByName returns the path to the file in the cloned repository.
+func (m *ResourceManager) RepositoryFilePathByName(name, path string) (string, error) {
+	if !m.doneSetup {
+		return "", fmt.Errorf("kubetest: resource manager isn't setup")
+	}
+	clonedPath, err := m.repoMgr.ClonedPathByRepoName(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(clonedPath, filepath.Clean(path)), nil
+}
+
+// RepositoryCommitHashByName returns the commit hash of HEAD of the cloned repository.
+func (m *ResourceManager) RepositoryCommitHashByName(name string) (string, error) {
+	if !m.doneSetup {
+		return "", fmt.Errorf("kubetest: resource manager isn't setup")
+	}
+	return m.repoMgr.CommitHashByRepoName(name)
+}
+
+// ChangedFilesByRepoName returns the changed files between the merge base and HEAD of the merged repository.
+func (m *ResourceManager) ChangedFilesByRepoName(name string) ([]string, error) {
+	if !m.doneSetup {
+		return nil, fmt.Errorf("kubetest: resource manager isn't setup")
+	}
+	return m.repoMgr.ChangedFilesByRepoName(name)
+}
+
+// ConfigMapValue returns the value of key in the ConfigMap of the namespace of TestJob.
+func (m *ResourceManager) ConfigMapValue(ctx context.Context, name, key string) (string, error) {
+	configMap, err := m.clientset.CoreV1().
+		ConfigMaps(m.namespace).
+		Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("kubetest: failed to read configmap %s: %w", name, err)
+	}
+	value, exists := configMap.Data[key]
+	if !exists {
+		return "", fmt.Errorf("kubetest: failed to find key %s in configmap %s", key, name)
+	}
+	return value, nil
+}
+
 func (m *ResourceManager) TokenPathByName(ctx context.Context, name string) (string, error) {
 	if !m.doneSetup {
 		return "", fmt.Errorf("kubetest: resource manager isn't setup")
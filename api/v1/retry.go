@@ -0,0 +1,178 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/goccy/kubejob"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	defaultRetryCount   = 2
+	defaultRetryBackoff = 1 * time.Second
+
+	podReasonEvicted  = "Evicted"
+	podReasonNodeLost = "NodeLost"
+)
+
+var defaultRetryConditions = []RetryCondition{
+	RetryConditionPendingTimeout,
+	RetryConditionPreInitFailure,
+	RetryConditionEviction,
+	RetryConditionNodeLost,
+}
+
+// taskRetryPolicy is the retry policy of the task that the default values are applied to.
+type taskRetryPolicy struct {
+	count      int
+	backoff    time.Duration
+	conditions map[RetryCondition]struct{}
+}
+
+func newTaskRetryPolicy(policy *RetryPolicy) (*taskRetryPolicy, error) {
+	if policy == nil {
+		return &taskRetryPolicy{
+			count:      defaultRetryCount,
+			backoff:    defaultRetryBackoff,
+			conditions: retryConditionSet(defaultRetryConditions),
+		}, nil
+	}
+	backoff, err := parseDuration(policy.Backoff)
+	if err != nil {
+		return nil, err
+	}
+	if backoff == 0 {
+		backoff = defaultRetryBackoff
+	}
+	conditions := policy.On
+	if len(conditions) == 0 {
+		conditions = defaultRetryConditions
+	}
+	return &taskRetryPolicy{
+		count:      policy.Count,
+		backoff:    backoff,
+		conditions: retryConditionSet(conditions),
+	}, nil
+}
+
+func retryConditionSet(conditions []RetryCondition) map[RetryCondition]struct{} {
+	set := make(map[RetryCondition]struct{}, len(conditions))
+	for _, condition := range conditions {
+		set[condition] = struct{}{}
+	}
+	return set
+}
+
+func (p *taskRetryPolicy) retryable(condition RetryCondition) bool {
+	_, exists := p.conditions[condition]
+	return exists
+}
+
+// retryConditionByError returns the condition that caused the error.
+// If the error isn't caused by any retry condition, returns false.
+func retryConditionByError(err error) (RetryCondition, bool) {
+	if err == nil {
+		return "", false
+	}
+	var (
+		pendingErr   *kubejob.PendingPhaseTimeoutError
+		preInitErr   *kubejob.PreInitError
+		imagePullErr *ImagePullBackOffError
+		failedJob    *kubejob.FailedJob
+	)
+	switch {
+	case errors.As(err, &pendingErr):
+		return RetryConditionPendingTimeout, true
+	case errors.As(err, &preInitErr):
+		return RetryConditionPreInitFailure, true
+	case errors.As(err, &imagePullErr):
+		return RetryConditionImagePullBackOff, true
+	case errors.As(err, &failedJob) && failedJob.Pod != nil:
+		switch failedJob.Pod.Status.Reason {
+		case podReasonEvicted:
+			return RetryConditionEviction, true
+		case podReasonNodeLost:
+			return RetryConditionNodeLost, true
+		}
+	}
+	return "", false
+}
+
+// withLatestPod returns FailedJob that has the latest state of the pod.
+// The pod of FailedJob is taken when the pod starts running, so it doesn't have the reason like Evicted or NodeLost.
+// If the latest state can't be taken, returns FailedJob as it is.
+func withLatestPod(ctx context.Context, failedJob *kubejob.FailedJob, execs []JobExecutor) *kubejob.FailedJob {
+	if len(execs) == 0 {
+		return failedJob
+	}
+	pod, err := execs[0].LatestPod(ctx)
+	if err != nil {
+		LoggerFromContext(ctx).Debug("failed to get the latest pod status: %s", err.Error())
+		return failedJob
+	}
+	if pod == nil {
+		return failedJob
+	}
+	return &kubejob.FailedJob{Pod: pod, Reason: failedJob.Reason}
+}
+
+// isLostPod reports whether the pod is evicted or the node running the pod is lost.
+func isLostPod(pod *corev1.Pod) bool {
+	if pod == nil {
+		return false
+	}
+	switch pod.Status.Reason {
+	case podReasonEvicted, podReasonNodeLost:
+		return true
+	}
+	return false
+}
+
+// lostPodError returns FailedJob of the lost pod that interrupted the main keys of the result.
+// It's used when the job itself finished without error because the exec streams of the lost pod ended.
+func lostPodError(result *TaskResult) error {
+	for _, r := range result.MainTaskResults() {
+		if r.lost() && isLostPod(r.Pod) {
+			return &kubejob.FailedJob{Pod: r.Pod}
+		}
+	}
+	return nil
+}
+
+// isExitError reports whether the error is caused by the exit status of the command.
+func isExitError(err error) bool {
+	var (
+		cmdErr    *kubejob.CommandError
+		failedJob *kubejob.FailedJob
+		exitErr   *exec.ExitError
+	)
+	switch {
+	case errors.As(err, &cmdErr):
+		return cmdErr.IsExitError()
+	case errors.As(err, &failedJob):
+		// FailedJob doesn't unwrap the reason.
+		return isExitError(failedJob.Reason)
+	case errors.As(err, &exitErr):
+		return true
+	}
+	return false
+}
+
+// ImagePullBackOffError the container of the pod can't pull the image.
+type ImagePullBackOffError struct {
+	Pod       string
+	Container string
+	Image     string
+	Message   string
+}
+
+func (e *ImagePullBackOffError) Error() string {
+	return fmt.Sprintf("kubetest: failed to pull image %s for container %s of pod %s: %s", e.Image, e.Container, e.Pod, e.Message)
+}
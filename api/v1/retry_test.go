@@ -0,0 +1,301 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/goccy/kubejob"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+type retryTestJob struct {
+	err error
+	// keys keys run by the job. each key runs on the container that has the key as TEST env.
+	keys []string
+	// keyErrs errors returned by the command of each key.
+	keyErrs map[string]error
+	// pod the latest state of the pod.
+	pod *corev1.Pod
+}
+
+func (j *retryTestJob) Spec() batchv1.JobSpec {
+	var spec batchv1.JobSpec
+	for _, key := range j.keys {
+		spec.Template.Spec.Containers = append(spec.Template.Spec.Containers, j.container(key))
+	}
+	return spec
+}
+
+func (j *retryTestJob) container(key string) corev1.Container {
+	return corev1.Container{Name: "test-" + key, Env: []corev1.EnvVar{{Name: "TEST", Value: key}}}
+}
+
+func (j *retryTestJob) PreInit(c TestJobContainer, cb PreInitCallback)         {}
+func (j *retryTestJob) Mount(_ func(context.Context, JobExecutor, bool) error) {}
+func (j *retryTestJob) FailOnImagePullBackOff()                                {}
+func (j *retryTestJob) RunWithExecutionHandler(_ context.Context, handler func([]JobExecutor) error) error {
+	if len(j.keys) > 0 {
+		execs := make([]JobExecutor, 0, len(j.keys))
+		for _, key := range j.keys {
+			execs = append(execs, &retryTestExecutor{container: j.container(key), err: j.keyErrs[key], pod: j.pod})
+		}
+		if err := handler(execs); err != nil {
+			return err
+		}
+	}
+	return j.err
+}
+
+type retryTestExecutor struct {
+	container corev1.Container
+	err       error
+	pod       *corev1.Pod
+}
+
+func (e *retryTestExecutor) Output(_ context.Context) ([]byte, error) { return nil, e.err }
+func (e *retryTestExecutor) ExecWithEnv(_ context.Context, _ []corev1.EnvVar) ([]byte, error) {
+	return nil, e.err
+}
+func (e *retryTestExecutor) ExecAsync(_ context.Context)                          {}
+func (e *retryTestExecutor) TerminationLog(_ context.Context, _ string) error     { return nil }
+func (e *retryTestExecutor) Stop(_ context.Context) error                         { return nil }
+func (e *retryTestExecutor) CopyFrom(_ context.Context, _ string, _ string) error { return nil }
+func (e *retryTestExecutor) CopyTo(_ context.Context, _ string, _ string) error   { return nil }
+func (e *retryTestExecutor) Container() corev1.Container                          { return e.container }
+func (e *retryTestExecutor) Pod() *corev1.Pod                                     { return nil }
+func (e *retryTestExecutor) LatestPod(_ context.Context) (*corev1.Pod, error)     { return e.pod, nil }
+func (e *retryTestExecutor) ResourceUsage() *ResourceUsage                        { return nil }
+func (e *retryTestExecutor) PrepareCommand(_ []string) ([]byte, error)            { return nil, nil }
+
+func TestRetryPolicy(t *testing.T) {
+	t.Run("RetryConditionByError", func(t *testing.T) {
+		for _, test := range []struct {
+			err       error
+			condition RetryCondition
+			exists    bool
+		}{
+			{err: &kubejob.PendingPhaseTimeoutError{}, condition: RetryConditionPendingTimeout, exists: true},
+			{err: fmt.Errorf("wrapped: %w", &kubejob.PreInitError{Err: errors.New("error")}), condition: RetryConditionPreInitFailure, exists: true},
+			{err: &ImagePullBackOffError{}, condition: RetryConditionImagePullBackOff, exists: true},
+			{err: &kubejob.FailedJob{Pod: &corev1.Pod{Status: corev1.PodStatus{Reason: "Evicted"}}}, condition: RetryConditionEviction, exists: true},
+			{err: &kubejob.FailedJob{Pod: &corev1.Pod{Status: corev1.PodStatus{Reason: "NodeLost"}}}, condition: RetryConditionNodeLost, exists: true},
+			{err: &kubejob.FailedJob{Pod: &corev1.Pod{}}},
+			{err: errors.New("error")},
+			{},
+		} {
+			condition, exists := retryConditionByError(test.err)
+			if condition != test.condition || exists != test.exists {
+				t.Fatalf("failed to get retry condition by %v: expected (%s, %v) but got (%s, %v)", test.err, test.condition, test.exists, condition, exists)
+			}
+		}
+	})
+	t.Run("DefaultConditions", func(t *testing.T) {
+		for _, retryPolicy := range []*RetryPolicy{nil, {Count: 1}} {
+			policy, err := newTaskRetryPolicy(retryPolicy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for condition, retryable := range map[RetryCondition]bool{
+				RetryConditionPendingTimeout:   true,
+				RetryConditionPreInitFailure:   true,
+				RetryConditionEviction:         true,
+				RetryConditionNodeLost:         true,
+				RetryConditionImagePullBackOff: false,
+			} {
+				if policy.retryable(condition) != retryable {
+					t.Fatalf("failed to get default retryable of %s: expected %v", condition, retryable)
+				}
+			}
+		}
+	})
+	t.Run("RunWithRetry", func(t *testing.T) {
+		ctx := WithLogger(context.Background(), NewLogger(os.Stdout, LogLevelDebug))
+		for _, test := range []struct {
+			name          string
+			policy        *RetryPolicy
+			err           error
+			expectedCount int
+		}{
+			{name: "default", err: &kubejob.PreInitError{Err: errors.New("error")}, expectedCount: 2},
+			{name: "no retry", policy: &RetryPolicy{Count: 0}, err: &kubejob.PreInitError{Err: errors.New("error")}, expectedCount: 0},
+			{name: "not retryable", policy: &RetryPolicy{Count: 3, Backoff: "1ms"}, err: &ImagePullBackOffError{}, expectedCount: 0},
+			{name: "retryable", policy: &RetryPolicy{Count: 3, Backoff: "1ms", On: []RetryCondition{RetryConditionImagePullBackOff}}, err: &ImagePullBackOffError{}, expectedCount: 3},
+		} {
+			test := test
+			t.Run(test.name, func(t *testing.T) {
+				policy, err := newTaskRetryPolicy(test.policy)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var createdNum int
+				task := &Task{
+					job: &retryTestJob{err: test.err},
+					createJob: func(context.Context, *StrategyKey) (Job, error) {
+						createdNum++
+						return &retryTestJob{err: test.err}, nil
+					},
+					retryPolicy: policy,
+				}
+				start := time.Now()
+				if _, err := task.Run(ctx); err == nil {
+					t.Fatal("expected error")
+				}
+				if createdNum != test.expectedCount {
+					t.Fatalf("failed to retry: expected %d but got %d ( %s )", test.expectedCount, createdNum, time.Since(start))
+				}
+			})
+		}
+	})
+	t.Run("LatestPodStatus", func(t *testing.T) {
+		ctx := WithLogger(context.Background(), NewLogger(os.Stdout, LogLevelDebug))
+		for _, test := range []struct {
+			name          string
+			reason        string
+			expectedCount int
+		}{
+			{name: "evicted", reason: "Evicted", expectedCount: 1},
+			{name: "node lost", reason: "NodeLost", expectedCount: 1},
+			{name: "failed", expectedCount: 0},
+		} {
+			test := test
+			t.Run(test.name, func(t *testing.T) {
+				policy, err := newTaskRetryPolicy(&RetryPolicy{Count: 1, Backoff: "1ms"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				newJob := func() Job {
+					return &retryTestJob{
+						// the pod of FailedJob is taken when the pod starts running, so it doesn't have the reason.
+						err:  &kubejob.FailedJob{Pod: &corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodRunning}}},
+						keys: []string{"A"},
+						pod:  &corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodFailed, Reason: test.reason}},
+					}
+				}
+				var createdNum int
+				task := &Task{
+					job: newJob(),
+					createJob: func(context.Context, *StrategyKey) (Job, error) {
+						createdNum++
+						return newJob(), nil
+					},
+					copyArtifact: func(context.Context, *SubTask) error { return nil },
+					retryPolicy:  policy,
+				}
+				if _, err := task.Run(ctx); err != nil {
+					t.Fatal(err)
+				}
+				if createdNum != test.expectedCount {
+					t.Fatalf("failed to retry by the latest pod status: expected %d but got %d", test.expectedCount, createdNum)
+				}
+			})
+		}
+	})
+	t.Run("RescheduleUnfinishedKeys", func(t *testing.T) {
+		ctx := WithLogger(context.Background(), NewLogger(os.Stdout, LogLevelDebug))
+		evicted := &kubejob.FailedJob{Pod: &corev1.Pod{Status: corev1.PodStatus{Reason: "Evicted"}}}
+		policy, err := newTaskRetryPolicy(&RetryPolicy{Count: 1, Backoff: "1ms"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rescheduledKeys []string
+		task := &Task{
+			job: &retryTestJob{
+				err:  evicted,
+				keys: []string{"A", "B", "C"},
+				keyErrs: map[string]error{
+					"B": &kubejob.CommandError{Message: "exit status 1"},
+					"C": &kubejob.CommandError{ReaderErr: errors.New("connection lost")},
+				},
+			},
+			createJob: func(_ context.Context, strategyKey *StrategyKey) (Job, error) {
+				rescheduledKeys = strategyKey.Keys
+				return &retryTestJob{keys: strategyKey.Keys}, nil
+			},
+			copyArtifact: func(context.Context, *SubTask) error { return nil },
+			strategyKey: &StrategyKey{
+				Keys:             []string{"A", "B", "C", "D"},
+				Env:              "TEST",
+				SubTaskScheduler: NewSubTaskScheduler(0),
+			},
+			retryPolicy: policy,
+		}
+		result, err := task.Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Err != nil {
+			t.Fatalf("unexpected error: %v", result.Err)
+		}
+		// C is interrupted and D has no result because the pod was evicted.
+		if len(rescheduledKeys) != 2 || rescheduledKeys[0] != "C" || rescheduledKeys[1] != "D" {
+			t.Fatalf("failed to reschedule unfinished keys: %v", rescheduledKeys)
+		}
+		keyToStatus := map[string]TaskResultStatus{}
+		for _, r := range result.MainTaskResults() {
+			keyToStatus[r.Name] = r.Status
+		}
+		expected := map[string]TaskResultStatus{
+			"A": TaskResultSuccess,
+			"B": TaskResultFailure,
+			"C": TaskResultSuccess,
+			"D": TaskResultSuccess,
+		}
+		if len(keyToStatus) != len(expected) {
+			t.Fatalf("failed to merge results: %v", keyToStatus)
+		}
+		for key, status := range expected {
+			if keyToStatus[key] != status {
+				t.Fatalf("failed to get status of %s: expected %s but got %s", key, status, keyToStatus[key])
+			}
+		}
+	})
+	t.Run("EvictedPodWithExitedCommand", func(t *testing.T) {
+		ctx := WithLogger(context.Background(), NewLogger(os.Stdout, LogLevelDebug))
+		policy, err := newTaskRetryPolicy(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rescheduledKeys []string
+		task := &Task{
+			// the exec stream of the evicted pod ends as if the command exited, and the job finishes without error.
+			job: &retryTestJob{
+				keys: []string{"A", "B"},
+				keyErrs: map[string]error{
+					"B": &kubejob.CommandError{Message: "exit status 137"},
+				},
+				pod: &corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodFailed, Reason: "Evicted"}},
+			},
+			createJob: func(_ context.Context, strategyKey *StrategyKey) (Job, error) {
+				rescheduledKeys = strategyKey.Keys
+				return &retryTestJob{keys: strategyKey.Keys}, nil
+			},
+			copyArtifact: func(context.Context, *SubTask) error { return nil },
+			strategyKey: &StrategyKey{
+				Keys:             []string{"A", "B"},
+				Env:              "TEST",
+				SubTaskScheduler: NewSubTaskScheduler(0),
+			},
+			retryPolicy: policy,
+		}
+		result, err := task.Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rescheduledKeys) != 1 || rescheduledKeys[0] != "B" {
+			t.Fatalf("failed to reschedule the key interrupted by eviction: %v", rescheduledKeys)
+		}
+		for _, r := range result.MainTaskResults() {
+			if r.Status != TaskResultSuccess {
+				t.Fatalf("failed to get status of %s: expected success but got %s", r.Name, r.Status)
+			}
+		}
+		if result.RetryNum != 1 {
+			t.Fatalf("failed to get retry num: expected 1 but got %d", result.RetryNum)
+		}
+	})
+}
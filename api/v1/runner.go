@@ -5,10 +5,12 @@ package v1
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"time"
 
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -34,6 +36,9 @@ func (m RunMode) String() string {
 	return "unknown"
 }
 
+// errDeadlineReached is returned by the step that is stopped halfway because the deadline is reached.
+var errDeadlineReached = errors.New("kubetest: the deadline is reached")
+
 type Runner struct {
 	cfg       *rest.Config
 	clientset *kubernetes.Clientset
@@ -78,77 +83,311 @@ func (r *Runner) Run(ctx context.Context, testjob TestJob) (*Report, error) {
 	}
 	defer resourceMgr.Cleanup()
 	builder := NewTaskBuilder(r.cfg, resourceMgr, testjob.Namespace, r.runMode)
-	var result Result
-	for _, step := range testjob.Spec.PreSteps {
-		step := step
-		r.logger.Info("run prestep: %s", step.Name)
-		task, err := builder.Build(ctx, &step)
-		if err != nil {
-			return nil, err
+	// deadlineCtx is used by preSteps and mainStep. postSteps and exporting artifacts run even if the deadline is reached.
+	deadlineCtx, cancel, err := r.deadlineContext(ctx, startedAt, testjob.Spec.Deadline)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	result := Result{job: testjob}
+	stepErr := r.runSteps(deadlineCtx, startedAt, builder, testjob, &result)
+	if stepErr != nil {
+		// the log and the report are written for the postSteps that run even if the steps failed.
+		result.status = ResultStatusError
+	}
+	if err := resourceMgr.WriteLog(r.logger); err != nil && stepErr == nil {
+		return nil, err
+	}
+	if err := resourceMgr.WriteReport(&result); err != nil && stepErr == nil {
+		return nil, err
+	}
+	postStepErr := r.runPostSteps(ctx, builder, testjob.Spec.PostSteps, &result, stepErr)
+	// the artifacts are exported even if the steps failed or the deadline is reached.
+	exportErr := resourceMgr.ExportArtifacts(ctx)
+	if stepErr != nil {
+		if postStepErr != nil {
+			r.logger.Warn("%s", postStepErr.Error())
 		}
-		preStepResult, err := task.Run(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("kubetest: failed to run prestep %s: %w", step.Name, err)
+		if exportErr != nil {
+			r.logger.Warn("%s", exportErr.Error())
 		}
-		for _, result := range preStepResult.MainTaskResults() {
-			if err := result.Error(); err != nil {
-				return nil, fmt.Errorf("kubetest: failed to run prestep %s: %w", step.Name, err)
-			}
+		return nil, stepErr
+	}
+	if postStepErr != nil {
+		if exportErr != nil {
+			r.logger.Warn("%s", exportErr.Error())
 		}
-		result.preStepResults = append(result.preStepResults, preStepResult)
+		return nil, postStepErr
+	}
+	if exportErr != nil {
+		return nil, exportErr
+	}
+	return result.toReport(), nil
+}
+
+// runSteps runs preSteps and mainStep, and sets the result of mainStep.
+func (r *Runner) runSteps(ctx context.Context, startedAt time.Time, builder *TaskBuilder, testjob TestJob, result *Result) error {
+	preStepResults, err := r.runPreSteps(ctx, builder, testjob.Spec.PreSteps, testjob.Spec.MaxConcurrentPreSteps)
+	if err != nil {
+		return err
 	}
+	result.preStepResults = preStepResults
 	scheduler := NewTaskScheduler(testjob.Spec.MainStep)
 	taskGroup, err := scheduler.Schedule(ctx, builder)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	taskResult, err := taskGroup.Run(ctx)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if strategy := testjob.Spec.MainStep.Strategy; strategy != nil && strategy.Retest && ctx.Err() == nil {
+		if err := r.retest(ctx, scheduler, builder, taskResult); err != nil {
+			return err
+		}
+	}
+	if strategy := testjob.Spec.MainStep.Strategy; strategy != nil && strategy.FlakyDetection != nil && ctx.Err() == nil {
+		if err := r.detectFlakyKeys(ctx, scheduler, builder, taskResult, strategy.FlakyDetection); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		r.logger.Warn("the deadline is reached. %d keys are skipped", taskResult.SkippedNum())
 	}
 	result.setByTaskResult(startedAt, taskResult)
-	if err := resourceMgr.WriteLog(r.logger); err != nil {
+	r.logHeaviestKeys(taskResult)
+	if strategy := testjob.Spec.MainStep.Strategy; strategy != nil && strategy.Quarantine != nil {
+		if err := r.updateQuarantinedKeys(strategy, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runPostSteps runs the postSteps whose condition is satisfied by the result of preSteps and mainStep.
+func (r *Runner) runPostSteps(ctx context.Context, builder *TaskBuilder, steps []PostStep, result *Result, stepErr error) error {
+	for _, step := range steps {
+		step := step
+		if !step.When.shouldRun(result.status, stepErr) {
+			r.logger.Info("skip poststep %s because the condition isn't satisfied", step.Name)
+			continue
+		}
+		r.logger.Info("run poststep: %s", step.Name)
+		postStepResults, err := r.runStep(ctx, builder, &step)
+		if err != nil {
+			return fmt.Errorf("kubetest: failed to run poststep %s: %w", step.Name, err)
+		}
+		result.postStepResults = append(result.postStepResults, postStepResults...)
+	}
+	return nil
+}
+
+// runStep runs preStep or postStep by TaskScheduler. If the step has strategy, the tasks are distributed by the keys.
+// Returns error if one of the tasks fails. If the step is stopped halfway by the deadline, returns errDeadlineReached
+// so that the caller doesn't use the incomplete result.
+func (r *Runner) runStep(ctx context.Context, builder *TaskBuilder, step Step) ([]*TaskResult, error) {
+	taskGroup, err := NewTaskSchedulerByStep(step).Schedule(ctx, builder)
+	if err != nil {
 		return nil, err
 	}
-	if err := resourceMgr.WriteReport(&result); err != nil {
+	rg, err := taskGroup.Run(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: %s", errDeadlineReached, err.Error())
+		}
 		return nil, err
 	}
-	for _, step := range testjob.Spec.PostSteps {
-		step := step
-		r.logger.Info("run poststep: %s", step.Name)
-		task, err := builder.Build(ctx, &step)
+	for _, result := range rg.results {
+		for _, subTaskResult := range result.MainTaskResults() {
+			if subTaskResult.Status == TaskResultSkipped {
+				// stopped by the deadline or skipped by the timeout of the step.
+				continue
+			}
+			if err := subTaskResult.Error(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if skippedNum := rg.SkippedNum(); skippedNum > 0 {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: %d keys are skipped", errDeadlineReached, skippedNum)
+		}
+		return nil, fmt.Errorf("kubetest: %d keys are skipped by the timeout", skippedNum)
+	}
+	return rg.results, nil
+}
+
+// deadlineContext returns the context that is canceled when the deadline is reached.
+// If deadline isn't specified, returns the context that is never canceled by the deadline.
+func (r *Runner) deadlineContext(ctx context.Context, startedAt time.Time, deadline string) (context.Context, context.CancelFunc, error) {
+	duration, err := parseDuration(deadline)
+	if err != nil {
+		return nil, nil, err
+	}
+	if duration == 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, nil
+	}
+	// the deadline cancels the context instead of context.WithDeadline,
+	// so the keys stopped by the deadline aren't regarded as timed out by the time limit of the step or key.
+	ctx, cancel := context.WithCancel(ctx)
+	timer := time.AfterFunc(time.Until(startedAt.Add(duration)), cancel)
+	return ctx, func() {
+		timer.Stop()
+		cancel()
+	}, nil
+}
+
+func (r *Runner) retest(ctx context.Context, scheduler *TaskScheduler, builder *TaskBuilder, taskResult *TaskResultGroup) error {
+	failedKeys := taskResult.FailedKeys()
+	if len(failedKeys) == 0 {
+		return nil
+	}
+	r.logger.Info("retest %d failed keys", len(failedKeys))
+	taskGroup, err := scheduler.RescheduleKeys(ctx, builder, failedKeys)
+	if err != nil {
+		return fmt.Errorf("kubetest: failed to schedule retest: %w", err)
+	}
+	retestResult, err := taskGroup.Run(ctx)
+	if err != nil {
+		return fmt.Errorf("kubetest: failed to run retest: %w", err)
+	}
+	taskResult.mergeRetestResult(retestResult)
+	return nil
+}
+
+const defaultFlakyDetectionCount = 3
+
+// detectFlakyKeys re-runs each failed key by new pods until it passes or the number of re-runs reaches the count.
+// Unlike retest, the results of re-runs don't replace the first result.
+func (r *Runner) detectFlakyKeys(ctx context.Context, scheduler *TaskScheduler, builder *TaskBuilder, taskResult *TaskResultGroup, detection *StrategyFlakyDetection) error {
+	failedKeys := taskResult.FailedKeys()
+	if len(failedKeys) == 0 {
+		return nil
+	}
+	count := detection.Count
+	if count == 0 {
+		count = defaultFlakyDetectionCount
+	}
+	r.logger.Info("re-run %d failed keys up to %d times to detect flaky keys", len(failedKeys), count)
+	keys := failedKeys
+	for i := 0; i < count && len(keys) > 0 && ctx.Err() == nil; i++ {
+		taskGroup, err := scheduler.RescheduleKeys(ctx, builder, keys)
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("kubetest: failed to schedule re-run for flaky detection: %w", err)
 		}
-		postStepResult, err := task.Run(ctx)
+		rerunResult, err := taskGroup.Run(ctx)
 		if err != nil {
-			return nil, fmt.Errorf("kubetest: failed to run poststep %s: %w", step.Name, err)
+			return fmt.Errorf("kubetest: failed to run re-run for flaky detection: %w", err)
 		}
-		for _, result := range postStepResult.MainTaskResults() {
-			if err := result.Error(); err != nil {
-				return nil, fmt.Errorf("kubetest: failed to run poststep %s: %w", step.Name, err)
-			}
+		taskResult.addRerunResult(rerunResult)
+		keys = rerunResult.FailedKeys()
+	}
+	for _, key := range failedKeys {
+		if classification := taskResult.classification(key); classification != "" {
+			r.logger.Info("%s is %s", key, classification)
 		}
-		result.postStepResults = append(result.postStepResults, postStepResult)
 	}
-	if err := resourceMgr.ExportArtifacts(ctx); err != nil {
-		return nil, err
+	return nil
+}
+
+const heaviestKeyNum = 5
+
+// logHeaviestKeys shows the keys that consumed the most resources to help to size resources of the containers.
+func (r *Runner) logHeaviestKeys(taskResult *TaskResultGroup) {
+	byMemory := taskResult.HeaviestResults(heaviestKeyNum, func(result *SubTaskResult) int64 {
+		return result.PeakMemoryBytes
+	})
+	if len(byMemory) > 0 {
+		r.logger.Info("heaviest keys by peak memory:")
+		for _, result := range byMemory {
+			r.logger.Info("  %s: %s", result.Name, resource.NewQuantity(result.PeakMemoryBytes, resource.BinarySI))
+		}
+	}
+	byCPUTime := taskResult.HeaviestResults(heaviestKeyNum, func(result *SubTaskResult) int64 {
+		return int64(result.CPUTime)
+	})
+	if len(byCPUTime) > 0 {
+		r.logger.Info("heaviest keys by CPU time:")
+		for _, result := range byCPUTime {
+			r.logger.Info("  %s: %s", result.Name, result.CPUTime.Round(time.Millisecond))
+		}
+	}
+}
+
+const defaultQuarantineReleaseThreshold = 10
+
+// updateQuarantinedKeys counts the consecutive successful runs of quarantined keys by the previous report,
+// and flags the keys that can be released from the quarantine.
+func (r *Runner) updateQuarantinedKeys(strategy *Strategy, result *Result) error {
+	var previous *Report
+	if path := strategy.Scheduler.PreviousReport; path != "" {
+		report, err := LoadReport(path)
+		if err != nil {
+			return err
+		}
+		previous = report
+	}
+	threshold := strategy.Quarantine.ReleaseThreshold
+	if threshold == 0 {
+		threshold = defaultQuarantineReleaseThreshold
+	}
+	updateSuccessStreak(result.details, previous, threshold)
+	if result.quarantinedFailureNum > 0 {
+		r.logger.Warn("%d quarantined keys failed. they don't affect the result", result.quarantinedFailureNum)
+	}
+	for _, detail := range result.details {
+		if detail.ReleaseCandidate {
+			r.logger.Info("quarantined key %s has passed %d consecutive runs. it can be released from the quarantine", detail.Name, detail.SuccessStreak)
+		}
+	}
+	return nil
+}
+
+// updateSuccessStreak sets the number of consecutive successful runs to the details of quarantined keys.
+// The skipped key keeps the streak of the previous report.
+func updateSuccessStreak(details []*ReportDetail, previous *Report, threshold int) {
+	nameToStreak := map[string]int{}
+	if previous != nil {
+		for _, detail := range previous.Details {
+			nameToStreak[detail.Name] = detail.SuccessStreak
+		}
+	}
+	for _, detail := range details {
+		if !detail.Quarantined {
+			continue
+		}
+		switch detail.Status {
+		case ResultStatusSuccess:
+			detail.SuccessStreak = nameToStreak[detail.Name] + 1
+		case ResultStatusSkipped:
+			detail.SuccessStreak = nameToStreak[detail.Name]
+		default:
+			detail.SuccessStreak = 0
+		}
+		detail.ReleaseCandidate = detail.SuccessStreak >= threshold
 	}
-	return result.toReport(), nil
 }
 
 type Result struct {
-	status          ResultStatus
-	startedAt       time.Time
-	elapsedTime     time.Duration
-	totalNum        int
-	successNum      int
-	failureNum      int
-	unknownNum      int
-	preStepResults  []*TaskResult
-	postStepResults []*TaskResult
-	taskResult      *TaskResultGroup
-	job             TestJob
+	status      ResultStatus
+	startedAt   time.Time
+	elapsedTime time.Duration
+	totalNum    int
+	successNum  int
+	failureNum  int
+	unknownNum  int
+	skippedNum  int
+	timeoutNum  int
+	// quarantinedFailureNum number of quarantined keys that failed or timed out.
+	quarantinedFailureNum int
+	flakyNum              int
+	brokenNum             int
+	details               []*ReportDetail
+	preStepResults        []*TaskResult
+	postStepResults       []*TaskResult
+	taskResult            *TaskResultGroup
+	job                   TestJob
 }
 
 func (r *Result) setByTaskResult(startedAt time.Time, taskResult *TaskResultGroup) {
@@ -157,14 +396,28 @@ func (r *Result) setByTaskResult(startedAt time.Time, taskResult *TaskResultGrou
 	r.totalNum = taskResult.TotalNum()
 	r.successNum = taskResult.SuccessNum()
 	r.failureNum = taskResult.FailureNum()
-	if r.totalNum != (r.successNum + r.failureNum) {
+	r.skippedNum = taskResult.SkippedNum()
+	r.timeoutNum = taskResult.TimeoutNum()
+	r.quarantinedFailureNum = taskResult.QuarantinedFailureNum()
+	r.flakyNum = taskResult.ClassifiedNum(FlakyClassificationFlaky)
+	r.brokenNum = taskResult.ClassifiedNum(FlakyClassificationBroken)
+	finishedNum := r.successNum + r.failureNum + r.skippedNum + r.timeoutNum + r.quarantinedFailureNum
+	if r.totalNum != finishedNum {
 		r.status = ResultStatusError
-		r.unknownNum = r.totalNum - (r.successNum + r.failureNum)
+		r.unknownNum = r.totalNum - finishedNum
 	}
 	r.taskResult = taskResult
+	r.details = taskResult.ToReportDetails()
 	r.elapsedTime = time.Since(startedAt)
 }
 
+func (r *Result) shard() *Shard {
+	if r.job.Spec.MainStep.Strategy == nil {
+		return nil
+	}
+	return r.job.Spec.MainStep.Strategy.Shard.DeepCopy()
+}
+
 func (r *Result) toReport() *Report {
 	return &Report{
 		Status:         r.status,
@@ -172,9 +425,28 @@ func (r *Result) toReport() *Report {
 		SuccessNum:     r.successNum,
 		FailureNum:     r.failureNum,
 		UnknownNum:     r.unknownNum,
+		SkippedNum:     r.skippedNum,
+		TimeoutNum:     r.timeoutNum,
 		StartedAt:      metav1.Time{r.startedAt},
 		ElapsedTimeSec: int64(r.elapsedTime.Seconds()),
-		Details:        r.taskResult.ToReportDetails(),
+		Details:        r.details,
 		ExtParam:       r.job.Spec.Log.ExtParam,
+		Shard:          r.shard(),
+		RetryNum:       r.retryNum(),
+
+		QuarantinedFailureNum: r.quarantinedFailureNum,
+		FlakyNum:              r.flakyNum,
+		BrokenNum:             r.brokenNum,
+	}
+}
+
+func (r *Result) retryNum() int {
+	retryNum := r.taskResult.RetryNum()
+	for _, result := range r.preStepResults {
+		retryNum += result.RetryNum
+	}
+	for _, result := range r.postStepResults {
+		retryNum += result.RetryNum
 	}
+	return retryNum
 }
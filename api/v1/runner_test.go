@@ -3,11 +3,14 @@ package v1
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -322,6 +325,372 @@ func TestRunner(t *testing.T) {
 			})
 		}
 	})
+	t.Run("prestep dependencies", func(t *testing.T) {
+		artifactPreStep := func(name string, dependsOn []string, mounts []string) PreStep {
+			container := corev1.Container{
+				Name:       name,
+				Image:      "alpine",
+				Command:    []string{"sh", "-c"},
+				Args:       []string{fmt.Sprintf(`echo %s > %s.log`, name, name)},
+				WorkingDir: filepath.Join("/", "work"),
+			}
+			var volumes []TestJobVolume
+			for _, mount := range mounts {
+				container.Args[0] = fmt.Sprintf("cat %s && ", mount) + container.Args[0]
+				container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+					Name:      mount,
+					MountPath: filepath.Join("/", "work", mount),
+				})
+				volumes = append(volumes, TestJobVolume{
+					Name: mount,
+					TestJobVolumeSource: TestJobVolumeSource{
+						Artifact: &ArtifactVolumeSource{Name: mount},
+					},
+				})
+			}
+			return PreStep{
+				Name:      name,
+				DependsOn: dependsOn,
+				Template: TestJobTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{
+						GenerateName: name + "-",
+					},
+					Spec: TestJobPodSpec{
+						Artifacts: []ArtifactSpec{
+							{
+								Name: name,
+								Container: ArtifactContainer{
+									Name: name,
+									Path: filepath.Join("/", "work", name+".log"),
+								},
+							},
+						},
+						Containers: []TestJobContainer{{Container: container}},
+						Volumes:    volumes,
+					},
+				},
+			}
+		}
+		for _, runMode := range getRunModes() {
+			t.Run(runMode.String(), func(t *testing.T) {
+				runner := NewRunner(getConfig(), runMode)
+				runner.SetLogger(NewLogger(os.Stdout, LogLevelDebug))
+				if _, err := runner.Run(context.Background(), TestJob{
+					ObjectMeta: testjobObjectMeta(),
+					Spec: TestJobSpec{
+						PreSteps: []PreStep{
+							artifactPreStep("merge", []string{"build", "download"}, []string{"build", "download"}),
+							artifactPreStep("build", nil, nil),
+							artifactPreStep("download", nil, nil),
+						},
+						MaxConcurrentPreSteps: 2,
+						MainStep: MainStep{
+							Template: TestJobTemplateSpec{
+								ObjectMeta: metav1.ObjectMeta{
+									GenerateName: "test-",
+								},
+								Spec: TestJobPodSpec{
+									Containers: []TestJobContainer{
+										{
+											Container: corev1.Container{
+												Name:    "test",
+												Image:   "alpine",
+												Command: []string{"echo"},
+												Args:    []string{"test"},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				}); err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+	})
+	t.Run("independent presteps", func(t *testing.T) {
+		for _, runMode := range getRunModes() {
+			t.Run(runMode.String(), func(t *testing.T) {
+				if runMode != RunModeLocal {
+					// skip because the preSteps wait for each other by the files on the local file system
+					t.Skip()
+				}
+				dir, err := os.MkdirTemp("", "presteps")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.RemoveAll(dir)
+				// each preStep finishes only after the other preStep starts, so they must run in parallel.
+				waitPreStep := func(name, other string) PreStep {
+					return PreStep{
+						Name:    name,
+						Timeout: "10s",
+						Template: TestJobTemplateSpec{
+							ObjectMeta: metav1.ObjectMeta{
+								GenerateName: name + "-",
+							},
+							Spec: TestJobPodSpec{
+								Containers: []TestJobContainer{
+									{
+										Container: corev1.Container{
+											Name:    name,
+											Image:   "alpine",
+											Command: []string{"sh", "-c"},
+											Args: []string{fmt.Sprintf(
+												"touch %s; while [ ! -f %s ]; do sleep 0.1; done",
+												filepath.Join(dir, name), filepath.Join(dir, other),
+											)},
+										},
+									},
+								},
+							},
+						},
+					}
+				}
+				runner := NewRunner(getConfig(), runMode)
+				runner.SetLogger(NewLogger(os.Stdout, LogLevelDebug))
+				if _, err := runner.Run(context.Background(), TestJob{
+					ObjectMeta: testjobObjectMeta(),
+					Spec: TestJobSpec{
+						PreSteps: []PreStep{
+							waitPreStep("build", "download"),
+							waitPreStep("download", "build"),
+						},
+						MaxConcurrentPreSteps: 2,
+						MainStep: MainStep{
+							Template: TestJobTemplateSpec{
+								ObjectMeta: metav1.ObjectMeta{
+									GenerateName: "test-",
+								},
+								Spec: TestJobPodSpec{
+									Containers: []TestJobContainer{
+										{
+											Container: corev1.Container{
+												Name:    "test",
+												Image:   "alpine",
+												Command: []string{"echo"},
+												Args:    []string{"test"},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				}); err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+	})
+	t.Run("prestep strategy", func(t *testing.T) {
+		for _, runMode := range getRunModes() {
+			t.Run(runMode.String(), func(t *testing.T) {
+				runner := NewRunner(getConfig(), runMode)
+				runner.SetLogger(NewLogger(os.Stdout, LogLevelDebug))
+				report, err := runner.Run(context.Background(), TestJob{
+					ObjectMeta: testjobObjectMeta(),
+					Spec: TestJobSpec{
+						PreSteps: []PreStep{
+							{
+								Name: "build",
+								Strategy: &Strategy{
+									Key: StrategyKeySpec{
+										Env: "TEST",
+										Source: StrategyKeySource{
+											Static: []string{"A", "B", "C"},
+										},
+									},
+									// all keys run in one pod, and the artifacts of the containers are merged.
+									// the containers of the same pod share the working directory in local mode, so they run one by one.
+									Scheduler: Scheduler{
+										MaxContainersPerPod:    3,
+										MaxConcurrentNumPerPod: 1,
+									},
+								},
+								Template: TestJobTemplateSpec{
+									ObjectMeta: metav1.ObjectMeta{
+										GenerateName: "build-",
+									},
+									Spec: TestJobPodSpec{
+										Artifacts: []ArtifactSpec{
+											{
+												Name: "build",
+												Container: ArtifactContainer{
+													Name: "build",
+													Path: filepath.Join("/", "work", "build.log"),
+												},
+											},
+										},
+										Containers: []TestJobContainer{
+											{
+												Container: corev1.Container{
+													Name:       "build",
+													Image:      "alpine",
+													Command:    []string{"sh", "-c"},
+													Args:       []string{"echo $TEST > build.log"},
+													WorkingDir: filepath.Join("/", "work"),
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+						MainStep: MainStep{
+							Template: TestJobTemplateSpec{
+								ObjectMeta: metav1.ObjectMeta{
+									GenerateName: "test-",
+								},
+								Spec: TestJobPodSpec{
+									Containers: []TestJobContainer{
+										{
+											Container: corev1.Container{
+												Name:       "test",
+												Image:      "alpine",
+												Command:    []string{"sh", "-c"},
+												Args:       []string{`test "$(cat build/*/build.log | sort | tr -d '\n')" = ABC`},
+												WorkingDir: filepath.Join("/", "work"),
+												VolumeMounts: []corev1.VolumeMount{
+													{
+														Name:      "build",
+														MountPath: filepath.Join("/", "work", "build"),
+													},
+												},
+											},
+										},
+									},
+									Volumes: []TestJobVolume{
+										{
+											Name: "build",
+											TestJobVolumeSource: TestJobVolumeSource{
+												Artifact: &ArtifactVolumeSource{Name: "build"},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if report.Status != ResultStatusSuccess {
+					t.Fatalf("failed to merge artifacts of prestep: expected success but got %s", report.Status)
+				}
+			})
+		}
+	})
+	t.Run("prestep cache", func(t *testing.T) {
+		for _, runMode := range getRunModes() {
+			t.Run(runMode.String(), func(t *testing.T) {
+				if runMode == RunModeDryRun {
+					// skip because the cache isn't used in dry-run mode
+					t.Skip()
+				}
+				cacheDir, err := os.MkdirTemp("", "cache")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.RemoveAll(cacheDir)
+				testjob := func(buildArg string) TestJob {
+					return TestJob{
+						ObjectMeta: testjobObjectMeta(),
+						Spec: TestJobSpec{
+							PreSteps: []PreStep{
+								{
+									Name: "build",
+									Cache: &PreStepCache{
+										Key:   "build-v1",
+										Store: CacheStore{Local: &LocalCacheStore{Path: cacheDir}},
+									},
+									Template: TestJobTemplateSpec{
+										ObjectMeta: metav1.ObjectMeta{
+											GenerateName: "build-",
+										},
+										Spec: TestJobPodSpec{
+											Artifacts: []ArtifactSpec{
+												{
+													Name: "build",
+													Container: ArtifactContainer{
+														Name: "build",
+														Path: filepath.Join("/", "work", "build.log"),
+													},
+												},
+											},
+											Containers: []TestJobContainer{
+												{
+													Container: corev1.Container{
+														Name:       "build",
+														Image:      "alpine",
+														Command:    []string{"sh", "-c"},
+														Args:       []string{buildArg},
+														WorkingDir: filepath.Join("/", "work"),
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+							MainStep: MainStep{
+								Template: TestJobTemplateSpec{
+									ObjectMeta: metav1.ObjectMeta{
+										GenerateName: "test-",
+									},
+									Spec: TestJobPodSpec{
+										Containers: []TestJobContainer{
+											{
+												Container: corev1.Container{
+													Name:       "test",
+													Image:      "alpine",
+													Command:    []string{"sh", "-c"},
+													Args:       []string{`test "$(cat build.log)" = cached`},
+													WorkingDir: filepath.Join("/", "work"),
+													VolumeMounts: []corev1.VolumeMount{
+														{
+															Name:      "build",
+															MountPath: filepath.Join("/", "work", "build.log"),
+														},
+													},
+												},
+											},
+										},
+										Volumes: []TestJobVolume{
+											{
+												Name: "build",
+												TestJobVolumeSource: TestJobVolumeSource{
+													Artifact: &ArtifactVolumeSource{Name: "build"},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					}
+				}
+				for _, buildArg := range []string{
+					"echo cached > build.log",
+					// the prestep is skipped by the cache stored by the previous run.
+					"exit 1",
+				} {
+					runner := NewRunner(getConfig(), runMode)
+					runner.SetLogger(NewLogger(os.Stdout, LogLevelDebug))
+					report, err := runner.Run(context.Background(), testjob(buildArg))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if report.Status != ResultStatusSuccess {
+						t.Fatalf("failed to use cached artifact: expected success but got %s", report.Status)
+					}
+				}
+			})
+		}
+	})
 	t.Run("static key based multiple tasks", func(t *testing.T) {
 		for _, runMode := range getRunModes() {
 			t.Run(runMode.String(), func(t *testing.T) {
@@ -330,18 +699,1062 @@ func TestRunner(t *testing.T) {
 				if _, err := runner.Run(context.Background(), TestJob{
 					ObjectMeta: testjobObjectMeta(),
 					Spec: TestJobSpec{
-						Repos: testRepos(),
+						Repos: testRepos(),
+						MainStep: MainStep{
+							Strategy: &Strategy{
+								Key: StrategyKeySpec{
+									Env: "TEST",
+									Source: StrategyKeySource{
+										Static: []string{"A", "B", "C"},
+									},
+								},
+								Scheduler: Scheduler{
+									MaxContainersPerPod:    10,
+									MaxConcurrentNumPerPod: 10,
+								},
+							},
+							Template: TestJobTemplateSpec{
+								ObjectMeta: metav1.ObjectMeta{
+									GenerateName: "test-",
+								},
+								Spec: TestJobPodSpec{
+									Containers: []TestJobContainer{
+										{
+											Container: corev1.Container{
+												Name:       "test",
+												Image:      "alpine",
+												Command:    []string{"sh", "-c"},
+												Args:       []string{"echo $TEST"},
+												WorkingDir: filepath.Join("/", "work"),
+												VolumeMounts: []corev1.VolumeMount{
+													testRepoVolumeMount(),
+												},
+											},
+										},
+									},
+									Volumes: []TestJobVolume{
+										testRepoVolume(),
+									},
+								},
+							},
+						},
+					},
+				}); err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+	})
+	t.Run("failed to get dynamic key command", func(t *testing.T) {
+		for _, runMode := range getRunModes() {
+			t.Run(runMode.String(), func(t *testing.T) {
+				if runMode == RunModeDryRun {
+					// skip because dry-run mode always successful
+					t.Skip()
+				}
+				runner := NewRunner(getConfig(), runMode)
+				runner.SetLogger(NewLogger(os.Stdout, LogLevelDebug))
+				if _, err := runner.Run(context.Background(), TestJob{
+					ObjectMeta: testjobObjectMeta(),
+					Spec: TestJobSpec{
+						MainStep: MainStep{
+							Strategy: &Strategy{
+								Key: StrategyKeySpec{
+									Env: "TEST",
+									Source: StrategyKeySource{
+										Dynamic: &StrategyDynamicKeySource{
+											Template: TestJobTemplateSpec{
+												ObjectMeta: metav1.ObjectMeta{
+													Name: "list",
+												},
+												Spec: TestJobPodSpec{
+													Containers: []TestJobContainer{
+														{
+															Container: corev1.Container{
+																Name:    "list",
+																Image:   "alpine",
+																Command: []string{"exit"},
+																Args:    []string{"1"},
+															},
+														},
+													},
+												},
+											},
+										},
+									},
+								},
+								Scheduler: Scheduler{
+									MaxContainersPerPod:    10,
+									MaxConcurrentNumPerPod: 10,
+								},
+							},
+							Template: TestJobTemplateSpec{
+								ObjectMeta: metav1.ObjectMeta{
+									Name: "test",
+								},
+								Spec: TestJobPodSpec{
+									Containers: []TestJobContainer{
+										{
+											Container: corev1.Container{
+												Name:       "test",
+												Image:      "alpine",
+												Command:    []string{"sh", "-c"},
+												Args:       []string{"echo $TEST"},
+												WorkingDir: filepath.Join("/", "work"),
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				}); err == nil {
+					t.Fatal("expected error")
+				}
+			})
+		}
+	})
+	t.Run("dynamic key based multiple tasks", func(t *testing.T) {
+		for _, runMode := range getRunModes() {
+			t.Run(runMode.String(), func(t *testing.T) {
+				runner := NewRunner(getConfig(), runMode)
+				runner.SetLogger(NewLogger(os.Stdout, LogLevelDebug))
+				if _, err := runner.Run(context.Background(), TestJob{
+					ObjectMeta: testjobObjectMeta(),
+					Spec: TestJobSpec{
+						Repos: testRepos(),
+						MainStep: MainStep{
+							Strategy: &Strategy{
+								Key: StrategyKeySpec{
+									Env: "TEST",
+									Source: StrategyKeySource{
+										Dynamic: &StrategyDynamicKeySource{
+											Template: TestJobTemplateSpec{
+												ObjectMeta: metav1.ObjectMeta{
+													Name: "list",
+												},
+												Spec: TestJobPodSpec{
+													Containers: []TestJobContainer{
+														{
+															Container: corev1.Container{
+																Name:    "list",
+																Image:   "alpine",
+																Command: []string{"sh", "-c"},
+																Args: []string{
+																	fmt.Sprintf(
+																		`echo "%s"`,
+																		string([]byte{
+																			'A', '\n',
+																			'B', '\n',
+																			'C', '\n',
+																			'D',
+																		}),
+																	),
+																},
+															},
+														},
+													},
+												},
+											},
+										},
+									},
+								},
+								Scheduler: Scheduler{
+									MaxContainersPerPod:    10,
+									MaxConcurrentNumPerPod: 10,
+								},
+							},
+							Template: TestJobTemplateSpec{
+								ObjectMeta: metav1.ObjectMeta{
+									Name: "test",
+								},
+								Spec: TestJobPodSpec{
+									Containers: []TestJobContainer{
+										{
+											Container: corev1.Container{
+												Name:       "test",
+												Image:      "alpine",
+												Command:    []string{"sh", "-c"},
+												Args:       []string{"echo $TEST"},
+												WorkingDir: filepath.Join("/", "work"),
+												VolumeMounts: []corev1.VolumeMount{
+													testRepoVolumeMount(),
+												},
+											},
+										},
+									},
+									Volumes: []TestJobVolume{
+										testRepoVolume(),
+									},
+								},
+							},
+						},
+					},
+				}); err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+	})
+	t.Run("retest failed keys", func(t *testing.T) {
+		for _, runMode := range getRunModes() {
+			t.Run(runMode.String(), func(t *testing.T) {
+				if runMode == RunModeDryRun {
+					// skip because dry-run mode always successful
+					t.Skip()
+				}
+				runner := NewRunner(getConfig(), runMode)
+				runner.SetLogger(NewLogger(os.Stdout, LogLevelDebug))
+				report, err := runner.Run(context.Background(), TestJob{
+					ObjectMeta: testjobObjectMeta(),
+					Spec: TestJobSpec{
+						MainStep: MainStep{
+							Strategy: &Strategy{
+								Key: StrategyKeySpec{
+									Env: "TEST",
+									Source: StrategyKeySource{
+										Static: []string{"A", "B", "C"},
+									},
+								},
+								Scheduler: Scheduler{
+									MaxContainersPerPod:    10,
+									MaxConcurrentNumPerPod: 10,
+								},
+								Retest: true,
+							},
+							Template: TestJobTemplateSpec{
+								ObjectMeta: metav1.ObjectMeta{
+									GenerateName: "test-",
+								},
+								Spec: TestJobPodSpec{
+									Containers: []TestJobContainer{
+										{
+											Container: corev1.Container{
+												Name:    "test",
+												Image:   "alpine",
+												Command: []string{"sh", "-c"},
+												Args:    []string{`test "$TEST" != "B"`},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if report.TotalNum != 3 {
+					t.Fatalf("failed to get total num: expected 3 but got %d", report.TotalNum)
+				}
+				if report.FailureNum != 1 {
+					t.Fatalf("failed to get failure num: expected 1 but got %d", report.FailureNum)
+				}
+				for _, detail := range report.Details {
+					expected := 1
+					if detail.Name == "B" {
+						expected = 2
+					}
+					if detail.Attempts != expected {
+						t.Fatalf("failed to get attempts of %s: expected %d but got %d", detail.Name, expected, detail.Attempts)
+					}
+				}
+			})
+		}
+	})
+	t.Run("retest repeated keys", func(t *testing.T) {
+		for _, runMode := range getRunModes() {
+			t.Run(runMode.String(), func(t *testing.T) {
+				if runMode != RunModeLocal {
+					// skip because the runs are counted by the file on the local file system
+					t.Skip()
+				}
+				dir, err := os.MkdirTemp("", "retest")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.RemoveAll(dir)
+				runsPath := filepath.Join(dir, "runs")
+				runner := NewRunner(getConfig(), runMode)
+				runner.SetLogger(NewLogger(os.Stdout, LogLevelDebug))
+				if _, err := runner.Run(context.Background(), TestJob{
+					ObjectMeta: testjobObjectMeta(),
+					Spec: TestJobSpec{
+						MainStep: MainStep{
+							Strategy: &Strategy{
+								Key: StrategyKeySpec{
+									Env: "TEST",
+									Source: StrategyKeySource{
+										Static: []string{"A", "B"},
+									},
+								},
+								Scheduler: Scheduler{
+									MaxContainersPerPod:    10,
+									MaxConcurrentNumPerPod: 10,
+								},
+								Retest: true,
+								Repeat: 3,
+							},
+							Template: TestJobTemplateSpec{
+								ObjectMeta: metav1.ObjectMeta{
+									GenerateName: "test-",
+								},
+								Spec: TestJobPodSpec{
+									Containers: []TestJobContainer{
+										{
+											Container: corev1.Container{
+												Name:    "test",
+												Image:   "alpine",
+												Command: []string{"sh", "-c"},
+												Args:    []string{fmt.Sprintf(`echo $TEST >> %s; test "$TEST" != "B"`, runsPath)},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				}); err != nil {
+					t.Fatal(err)
+				}
+				runs, err := os.ReadFile(runsPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// B runs 3 times by repeat, and once more by retest.
+				if num := strings.Count(string(runs), "B"); num != 4 {
+					t.Fatalf("failed to get the number of runs of B: expected 4 but got %d", num)
+				}
+			})
+		}
+	})
+	t.Run("queue mode", func(t *testing.T) {
+		for _, runMode := range getRunModes() {
+			t.Run(runMode.String(), func(t *testing.T) {
+				runner := NewRunner(getConfig(), runMode)
+				runner.SetLogger(NewLogger(os.Stdout, LogLevelDebug))
+				report, err := runner.Run(context.Background(), TestJob{
+					ObjectMeta: testjobObjectMeta(),
+					Spec: TestJobSpec{
+						MainStep: MainStep{
+							Strategy: &Strategy{
+								Key: StrategyKeySpec{
+									Env: "TEST",
+									Source: StrategyKeySource{
+										Static: []string{"A", "B", "C", "D", "E"},
+									},
+								},
+								Scheduler: Scheduler{
+									MaxContainersPerPod:    3,
+									MaxConcurrentNumPerPod: 2,
+									Mode:                   SchedulerModeQueue,
+								},
+							},
+							Template: TestJobTemplateSpec{
+								ObjectMeta: metav1.ObjectMeta{
+									GenerateName: "test-",
+								},
+								Spec: TestJobPodSpec{
+									Containers: []TestJobContainer{
+										{
+											Container: corev1.Container{
+												Name:    "test",
+												Image:   "alpine",
+												Command: []string{"sh", "-c"},
+												Args:    []string{"echo $TEST"},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if report.TotalNum != 5 {
+					t.Fatalf("failed to get total num: expected 5 but got %d", report.TotalNum)
+				}
+				if report.SuccessNum != 5 {
+					t.Fatalf("failed to get success num: expected 5 but got %d", report.SuccessNum)
+				}
+			})
+		}
+	})
+	t.Run("matrix keys", func(t *testing.T) {
+		for _, runMode := range getRunModes() {
+			t.Run(runMode.String(), func(t *testing.T) {
+				runner := NewRunner(getConfig(), runMode)
+				runner.SetLogger(NewLogger(os.Stdout, LogLevelDebug))
+				report, err := runner.Run(context.Background(), TestJob{
+					ObjectMeta: testjobObjectMeta(),
+					Spec: TestJobSpec{
+						MainStep: MainStep{
+							Strategy: &Strategy{
+								Key: StrategyKeySpec{
+									Matrix: []StrategyMatrixDimension{
+										{
+											Env: "VERSION",
+											Source: StrategyKeySource{
+												Static: []string{"1", "2"},
+											},
+										},
+										{
+											Env: "PACKAGE",
+											Source: StrategyKeySource{
+												Static: []string{"A", "B", "C"},
+											},
+										},
+									},
+								},
+								Scheduler: Scheduler{
+									MaxContainersPerPod:    4,
+									MaxConcurrentNumPerPod: 2,
+								},
+							},
+							Template: TestJobTemplateSpec{
+								ObjectMeta: metav1.ObjectMeta{
+									GenerateName: "test-",
+								},
+								Spec: TestJobPodSpec{
+									Containers: []TestJobContainer{
+										{
+											Container: corev1.Container{
+												Name:    "test",
+												Image:   "alpine",
+												Command: []string{"sh", "-c"},
+												Args:    []string{`test -n "$VERSION" && test -n "$PACKAGE"`},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if report.TotalNum != 6 {
+					t.Fatalf("failed to get total num: expected 6 but got %d", report.TotalNum)
+				}
+				if report.SuccessNum != 6 {
+					t.Fatalf("failed to get success num: expected 6 but got %d", report.SuccessNum)
+				}
+				names := map[string]struct{}{}
+				for _, detail := range report.Details {
+					names[detail.Name] = struct{}{}
+				}
+				for _, name := range []string{"VERSION=1,PACKAGE=A", "VERSION=2,PACKAGE=C"} {
+					if _, exists := names[name]; !exists {
+						t.Fatalf("failed to find %s in report details: %v", name, report.Details)
+					}
+				}
+			})
+		}
+	})
+	t.Run("batch keys per container", func(t *testing.T) {
+		for _, runMode := range getRunModes() {
+			t.Run(runMode.String(), func(t *testing.T) {
+				if runMode == RunModeDryRun {
+					// skip because dry-run mode always successful
+					t.Skip()
+				}
+				runner := NewRunner(getConfig(), runMode)
+				runner.SetLogger(NewLogger(os.Stdout, LogLevelDebug))
+				report, err := runner.Run(context.Background(), TestJob{
+					ObjectMeta: testjobObjectMeta(),
+					Spec: TestJobSpec{
+						MainStep: MainStep{
+							Strategy: &Strategy{
+								Key: StrategyKeySpec{
+									Env: "TEST",
+									Source: StrategyKeySource{
+										Static: []string{"A", "B", "C", "D", "E"},
+									},
+								},
+								Scheduler: Scheduler{
+									MaxContainersPerPod:    2,
+									MaxConcurrentNumPerPod: 2,
+									KeysPerContainer:       2,
+									KeyDelimiter:           ",",
+									ResultMarker:           "::result::",
+								},
+							},
+							Template: TestJobTemplateSpec{
+								ObjectMeta: metav1.ObjectMeta{
+									GenerateName: "test-",
+								},
+								Spec: TestJobPodSpec{
+									Containers: []TestJobContainer{
+										{
+											Container: corev1.Container{
+												Name:    "test",
+												Image:   "alpine",
+												Command: []string{"sh", "-c"},
+												Args: []string{
+													`for key in $(echo $TEST | tr ',' ' '); do if [ "$key" = "C" ]; then echo "::result:: failure $key"; else echo "::result:: success $key"; fi; done`,
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if report.TotalNum != 5 {
+					t.Fatalf("failed to get total num: expected 5 but got %d", report.TotalNum)
+				}
+				if report.SuccessNum != 4 {
+					t.Fatalf("failed to get success num: expected 4 but got %d", report.SuccessNum)
+				}
+				if report.FailureNum != 1 {
+					t.Fatalf("failed to get failure num: expected 1 but got %d", report.FailureNum)
+				}
+				for _, detail := range report.Details {
+					if detail.Name == "C" && detail.Status != ResultStatusFailure {
+						t.Fatalf("failed to get status of C: %s", detail.Status)
+					}
+				}
+			})
+		}
+	})
+	t.Run("max pods per step", func(t *testing.T) {
+		for _, runMode := range getRunModes() {
+			t.Run(runMode.String(), func(t *testing.T) {
+				if runMode == RunModeDryRun {
+					// skip because dry-run mode always successful
+					t.Skip()
+				}
+				runningDir, err := os.MkdirTemp("", "running")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.RemoveAll(runningDir)
+				runner := NewRunner(getConfig(), runMode)
+				runner.SetLogger(NewLogger(os.Stdout, LogLevelDebug))
+				report, err := runner.Run(context.Background(), TestJob{
+					ObjectMeta: testjobObjectMeta(),
+					Spec: TestJobSpec{
+						MainStep: MainStep{
+							Strategy: &Strategy{
+								Key: StrategyKeySpec{
+									Env: "TEST",
+									Source: StrategyKeySource{
+										Static: []string{"A", "B", "C", "D", "E", "F"},
+									},
+								},
+								Scheduler: Scheduler{
+									MaxContainersPerPod:    1,
+									MaxConcurrentNumPerPod: 1,
+									MaxPodsPerStep:         2,
+								},
+							},
+							Template: TestJobTemplateSpec{
+								ObjectMeta: metav1.ObjectMeta{
+									GenerateName: "test-",
+								},
+								Spec: TestJobPodSpec{
+									Containers: []TestJobContainer{
+										{
+											Container: corev1.Container{
+												Name:    "test",
+												Image:   "alpine",
+												Command: []string{"sh", "-c"},
+												Args: []string{
+													`touch $RUNNING_DIR/$TEST; sleep 0.2; num=$(ls $RUNNING_DIR | wc -l); rm $RUNNING_DIR/$TEST; test $num -le 2`,
+												},
+												Env: []corev1.EnvVar{
+													{
+														Name:  "RUNNING_DIR",
+														Value: runningDir,
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if report.SuccessNum != 6 {
+					t.Fatalf("failed to get success num: expected 6 but got %d", report.SuccessNum)
+				}
+			})
+		}
+	})
+	t.Run("fail fast", func(t *testing.T) {
+		for _, runMode := range getRunModes() {
+			t.Run(runMode.String(), func(t *testing.T) {
+				if runMode == RunModeDryRun {
+					// skip because dry-run mode always successful
+					t.Skip()
+				}
+				runner := NewRunner(getConfig(), runMode)
+				runner.SetLogger(NewLogger(os.Stdout, LogLevelDebug))
+				report, err := runner.Run(context.Background(), TestJob{
+					ObjectMeta: testjobObjectMeta(),
+					Spec: TestJobSpec{
+						MainStep: MainStep{
+							Strategy: &Strategy{
+								Key: StrategyKeySpec{
+									Env: "TEST",
+									Source: StrategyKeySource{
+										Static: []string{"A", "B", "C", "D"},
+									},
+								},
+								Scheduler: Scheduler{
+									MaxContainersPerPod:    1,
+									MaxConcurrentNumPerPod: 1,
+									MaxPodsPerStep:         2,
+								},
+								FailFast: true,
+							},
+							Template: TestJobTemplateSpec{
+								ObjectMeta: metav1.ObjectMeta{
+									GenerateName: "test-",
+								},
+								Spec: TestJobPodSpec{
+									Containers: []TestJobContainer{
+										{
+											Container: corev1.Container{
+												Name:    "test",
+												Image:   "alpine",
+												Command: []string{"sh", "-c"},
+												Args:    []string{`if [ "$TEST" = "A" ]; then exit 1; fi; sleep 30`},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if report.Status != ResultStatusFailure {
+					t.Fatalf("failed to get status: expected failure but got %s", report.Status)
+				}
+				if report.FailureNum != 1 {
+					t.Fatalf("failed to get failure num: expected 1 but got %d", report.FailureNum)
+				}
+				if report.SkippedNum != 3 {
+					t.Fatalf("failed to get skipped num: expected 3 but got %d", report.SkippedNum)
+				}
+				if report.ElapsedTimeSec >= 30 {
+					t.Fatalf("failed to stop running keys: elapsed time %d sec", report.ElapsedTimeSec)
+				}
+			})
+		}
+	})
+	t.Run("key timeout", func(t *testing.T) {
+		for _, runMode := range getRunModes() {
+			t.Run(runMode.String(), func(t *testing.T) {
+				if runMode == RunModeDryRun {
+					// skip because dry-run mode always successful
+					t.Skip()
+				}
+				runner := NewRunner(getConfig(), runMode)
+				runner.SetLogger(NewLogger(os.Stdout, LogLevelDebug))
+				report, err := runner.Run(context.Background(), TestJob{
+					ObjectMeta: testjobObjectMeta(),
+					Spec: TestJobSpec{
+						MainStep: MainStep{
+							Strategy: &Strategy{
+								Key: StrategyKeySpec{
+									Env: "TEST",
+									Source: StrategyKeySource{
+										Static: []string{"A", "B"},
+									},
+								},
+								Scheduler: Scheduler{
+									MaxContainersPerPod:    2,
+									MaxConcurrentNumPerPod: 2,
+								},
+								KeyTimeout: "1s",
+							},
+							Template: TestJobTemplateSpec{
+								ObjectMeta: metav1.ObjectMeta{
+									GenerateName: "test-",
+								},
+								Spec: TestJobPodSpec{
+									// the artifact of the timed out key is copied after its container is stopped in kubernetes mode.
+									Artifacts: []ArtifactSpec{
+										{
+											Name: "out",
+											Container: ArtifactContainer{
+												Name: "test",
+												Path: filepath.Join("/", "work", "out"),
+											},
+										},
+									},
+									Containers: []TestJobContainer{
+										{
+											Container: corev1.Container{
+												Name:       "test",
+												Image:      "alpine",
+												Command:    []string{"sh", "-c"},
+												Args:       []string{`echo $TEST > out; if [ "$TEST" = "A" ]; then sleep 30; fi`},
+												WorkingDir: filepath.Join("/", "work"),
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if report.TimeoutNum != 1 {
+					t.Fatalf("failed to get timeout num: expected 1 but got %d", report.TimeoutNum)
+				}
+				if report.SuccessNum != 1 {
+					t.Fatalf("failed to get success num: expected 1 but got %d", report.SuccessNum)
+				}
+				for _, detail := range report.Details {
+					if detail.Name == "A" && detail.Status != ResultStatusTimeout {
+						t.Fatalf("failed to get status of A: expected timeout but got %s", detail.Status)
+					}
+				}
+			})
+		}
+	})
+	t.Run("deadline", func(t *testing.T) {
+		for _, runMode := range getRunModes() {
+			t.Run(runMode.String(), func(t *testing.T) {
+				if runMode == RunModeDryRun {
+					// skip because dry-run mode finishes immediately
+					t.Skip()
+				}
+				runner := NewRunner(getConfig(), runMode)
+				runner.SetLogger(NewLogger(os.Stdout, LogLevelDebug))
+				report, err := runner.Run(context.Background(), TestJob{
+					ObjectMeta: testjobObjectMeta(),
+					Spec: TestJobSpec{
+						Deadline: "1s",
+						MainStep: MainStep{
+							Strategy: &Strategy{
+								Key: StrategyKeySpec{
+									Env: "TEST",
+									Source: StrategyKeySource{
+										Static: []string{"A", "B", "C", "D"},
+									},
+								},
+								Scheduler: Scheduler{
+									MaxContainersPerPod:    1,
+									MaxConcurrentNumPerPod: 1,
+									MaxPodsPerStep:         1,
+								},
+							},
+							Template: TestJobTemplateSpec{
+								ObjectMeta: metav1.ObjectMeta{
+									GenerateName: "test-",
+								},
+								Spec: TestJobPodSpec{
+									Containers: []TestJobContainer{
+										{
+											Container: corev1.Container{
+												Name:    "test",
+												Image:   "alpine",
+												Command: []string{"sh", "-c"},
+												Args:    []string{"sleep 30"},
+											},
+										},
+									},
+								},
+							},
+						},
+						PostSteps: []PostStep{
+							{
+								Name: "post-step",
+								Template: TestJobTemplateSpec{
+									ObjectMeta: metav1.ObjectMeta{
+										GenerateName: "post-",
+									},
+									Spec: TestJobPodSpec{
+										Containers: []TestJobContainer{
+											{
+												Container: corev1.Container{
+													Name:    "post",
+													Image:   "alpine",
+													Command: []string{"echo"},
+													Args:    []string{"post"},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				// the key stopped by the deadline is skipped because it doesn't exceed the time limit of the key.
+				if report.TimeoutNum != 0 {
+					t.Fatalf("failed to get timeout num: expected 0 but got %d", report.TimeoutNum)
+				}
+				if report.SkippedNum != 4 {
+					t.Fatalf("failed to get skipped num: expected 4 but got %d", report.SkippedNum)
+				}
+				if report.Status != ResultStatusSuccess {
+					t.Fatalf("skipped keys must not be regarded as failure: %s", report.Status)
+				}
+			})
+		}
+	})
+	t.Run("export artifacts after step failure", func(t *testing.T) {
+		for _, runMode := range getRunModes() {
+			t.Run(runMode.String(), func(t *testing.T) {
+				if runMode == RunModeDryRun {
+					// skip because dry-run mode doesn't fail the command
+					t.Skip()
+				}
+				exportDir, err := os.MkdirTemp("", "exported_artifacts")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.RemoveAll(exportDir)
+
+				runner := NewRunner(getConfig(), runMode)
+				runner.SetLogger(NewLogger(os.Stdout, LogLevelDebug))
+				if _, err := runner.Run(context.Background(), TestJob{
+					ObjectMeta: testjobObjectMeta(),
+					Spec: TestJobSpec{
+						PreSteps: []PreStep{
+							{
+								Name: "failed-prestep",
+								Template: TestJobTemplateSpec{
+									ObjectMeta: metav1.ObjectMeta{
+										GenerateName: "prestep-",
+									},
+									Spec: TestJobPodSpec{
+										Artifacts: []ArtifactSpec{
+											{
+												Name: "prestep-log",
+												Container: ArtifactContainer{
+													Name: "prestep",
+													Path: filepath.Join("/", "work", "log"),
+												},
+											},
+										},
+										Containers: []TestJobContainer{
+											{
+												Container: corev1.Container{
+													Name:       "prestep",
+													Image:      "alpine",
+													Command:    []string{"sh", "-c"},
+													Args:       []string{"echo failed > log; exit 1"},
+													WorkingDir: filepath.Join("/", "work"),
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+						MainStep: MainStep{
+							Template: TestJobTemplateSpec{
+								ObjectMeta: metav1.ObjectMeta{
+									GenerateName: "test-",
+								},
+								Spec: TestJobPodSpec{
+									Containers: []TestJobContainer{
+										{
+											Container: corev1.Container{
+												Name:    "test",
+												Image:   "alpine",
+												Command: []string{"echo"},
+												Args:    []string{"test"},
+											},
+										},
+									},
+								},
+							},
+						},
+						ExportArtifacts: []ExportArtifact{
+							{
+								Name: "prestep-log",
+								Path: exportDir,
+							},
+						},
+					},
+				}); err == nil {
+					t.Fatal("expected error of the failed prestep")
+				}
+				artifacts, err := filepath.Glob(filepath.Join(exportDir, "*"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(artifacts) == 0 {
+					t.Fatal("artifacts must be exported even if the step failed")
+				}
+			})
+		}
+	})
+	t.Run("deadline during prestep", func(t *testing.T) {
+		container := func(name, command string) TestJobContainer {
+			return TestJobContainer{
+				Container: corev1.Container{
+					Name:       name,
+					Image:      "alpine",
+					Command:    []string{"sh", "-c"},
+					Args:       []string{command},
+					WorkingDir: filepath.Join("/", "work"),
+				},
+			}
+		}
+		for _, runMode := range getRunModes() {
+			t.Run(runMode.String(), func(t *testing.T) {
+				if runMode == RunModeDryRun {
+					// skip because dry-run mode finishes immediately
+					t.Skip()
+				}
+				postContainer := container("post", "test -s report.json")
+				postContainer.VolumeMounts = []corev1.VolumeMount{
+					{
+						Name:      "report",
+						MountPath: filepath.Join("/", "work", "report.json"),
+					},
+				}
+				runner := NewRunner(getConfig(), runMode)
+				runner.SetLogger(NewLogger(os.Stdout, LogLevelDebug))
+				startedAt := time.Now()
+				report, err := runner.Run(context.Background(), TestJob{
+					ObjectMeta: testjobObjectMeta(),
+					Spec: TestJobSpec{
+						Deadline: "1s",
+						PreSteps: []PreStep{
+							{
+								Name: "prestep",
+								Template: TestJobTemplateSpec{
+									ObjectMeta: metav1.ObjectMeta{
+										GenerateName: "prestep-",
+									},
+									Spec: TestJobPodSpec{
+										Containers: []TestJobContainer{container("prestep", "sleep 30")},
+									},
+								},
+							},
+						},
+						MainStep: MainStep{
+							Strategy: &Strategy{
+								Key: StrategyKeySpec{
+									Env: "TEST",
+									Source: StrategyKeySource{
+										Static: []string{"A", "B"},
+									},
+								},
+								Scheduler: Scheduler{
+									MaxContainersPerPod:    2,
+									MaxConcurrentNumPerPod: 2,
+								},
+							},
+							Template: TestJobTemplateSpec{
+								ObjectMeta: metav1.ObjectMeta{
+									GenerateName: "test-",
+								},
+								Spec: TestJobPodSpec{
+									Containers: []TestJobContainer{container("test", "echo $TEST")},
+								},
+							},
+						},
+						PostSteps: []PostStep{
+							{
+								Name: "post-step",
+								Template: TestJobTemplateSpec{
+									ObjectMeta: metav1.ObjectMeta{
+										GenerateName: "post-",
+									},
+									Spec: TestJobPodSpec{
+										Containers: []TestJobContainer{postContainer},
+										Volumes: []TestJobVolume{
+											{
+												Name: "report",
+												TestJobVolumeSource: TestJobVolumeSource{
+													Report: &ReportVolumeSource{
+														Format: ReportFormatTypeJSON,
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if elapsedTime := time.Since(startedAt); elapsedTime > 20*time.Second {
+					t.Fatalf("the prestep isn't stopped by the deadline: %s", elapsedTime)
+				}
+				if report.SkippedNum != 2 {
+					t.Fatalf("failed to get skipped num: expected 2 but got %d", report.SkippedNum)
+				}
+			})
+		}
+	})
+	t.Run("structured dynamic keys", func(t *testing.T) {
+		for _, runMode := range getRunModes() {
+			t.Run(runMode.String(), func(t *testing.T) {
+				if runMode == RunModeDryRun {
+					// skip because dry-run mode always successful
+					return
+				}
+				runner := NewRunner(getConfig(), runMode)
+				runner.SetLogger(NewLogger(os.Stdout, LogLevelDebug))
+				report, err := runner.Run(context.Background(), TestJob{
+					ObjectMeta: testjobObjectMeta(),
+					Spec: TestJobSpec{
 						MainStep: MainStep{
 							Strategy: &Strategy{
 								Key: StrategyKeySpec{
 									Env: "TEST",
 									Source: StrategyKeySource{
-										Static: []string{"A", "B", "C"},
+										Dynamic: &StrategyDynamicKeySource{
+											Template: TestJobTemplateSpec{
+												ObjectMeta: metav1.ObjectMeta{
+													GenerateName: "test-",
+												},
+												Spec: TestJobPodSpec{
+													Containers: []TestJobContainer{
+														{
+															Container: corev1.Container{
+																Name:    "key",
+																Image:   "alpine",
+																Command: []string{"sh", "-c"},
+																Args: []string{
+																	`echo '{"name":"A","env":[{"name":"EXPECTED","value":"A"}],"elapsedTimeSec":10}'; echo '{"name":"B","env":[{"name":"EXPECTED","value":"B"}]}'; echo '{"name":"C","env":[{"name":"EXPECTED","value":"C"}]}'`,
+																},
+															},
+														},
+													},
+												},
+											},
+											Format: StrategyDynamicKeyFormatJSON,
+											Filter: "[AB]",
+										},
 									},
 								},
 								Scheduler: Scheduler{
-									MaxContainersPerPod:    10,
-									MaxConcurrentNumPerPod: 10,
+									MaxContainersPerPod:    1,
+									MaxConcurrentNumPerPod: 1,
 								},
 							},
 							Template: TestJobTemplateSpec{
@@ -352,86 +1765,98 @@ func TestRunner(t *testing.T) {
 									Containers: []TestJobContainer{
 										{
 											Container: corev1.Container{
-												Name:       "test",
-												Image:      "alpine",
-												Command:    []string{"sh", "-c"},
-												Args:       []string{"echo $TEST"},
-												WorkingDir: filepath.Join("/", "work"),
-												VolumeMounts: []corev1.VolumeMount{
-													testRepoVolumeMount(),
-												},
+												Name:    "test",
+												Image:   "alpine",
+												Command: []string{"sh", "-c"},
+												Args:    []string{`test "$TEST" = "$EXPECTED"`},
 											},
 										},
 									},
-									Volumes: []TestJobVolume{
-										testRepoVolume(),
-									},
 								},
 							},
 						},
 					},
-				}); err != nil {
+				})
+				if err != nil {
 					t.Fatal(err)
 				}
+				if report.TotalNum != 2 {
+					t.Fatalf("failed to get total num: expected 2 but got %d", report.TotalNum)
+				}
+				if report.SuccessNum != 2 {
+					t.Fatalf("failed to get success num: expected 2 but got %d", report.SuccessNum)
+				}
 			})
 		}
 	})
-	t.Run("failed to get dynamic key command", func(t *testing.T) {
+	t.Run("matrix over structured dynamic keys", func(t *testing.T) {
 		for _, runMode := range getRunModes() {
 			t.Run(runMode.String(), func(t *testing.T) {
 				if runMode == RunModeDryRun {
 					// skip because dry-run mode always successful
-					t.Skip()
+					return
 				}
 				runner := NewRunner(getConfig(), runMode)
 				runner.SetLogger(NewLogger(os.Stdout, LogLevelDebug))
-				if _, err := runner.Run(context.Background(), TestJob{
+				report, err := runner.Run(context.Background(), TestJob{
 					ObjectMeta: testjobObjectMeta(),
 					Spec: TestJobSpec{
 						MainStep: MainStep{
 							Strategy: &Strategy{
 								Key: StrategyKeySpec{
-									Env: "TEST",
-									Source: StrategyKeySource{
-										Dynamic: &StrategyDynamicKeySource{
-											Template: TestJobTemplateSpec{
-												ObjectMeta: metav1.ObjectMeta{
-													Name: "list",
-												},
-												Spec: TestJobPodSpec{
-													Containers: []TestJobContainer{
-														{
-															Container: corev1.Container{
-																Name:    "list",
-																Image:   "alpine",
-																Command: []string{"exit"},
-																Args:    []string{"1"},
+									Matrix: []StrategyMatrixDimension{
+										{
+											Env: "VERSION",
+											Source: StrategyKeySource{
+												Static: []string{"1", "2"},
+											},
+										},
+										{
+											Env: "PACKAGE",
+											Source: StrategyKeySource{
+												Dynamic: &StrategyDynamicKeySource{
+													Template: TestJobTemplateSpec{
+														ObjectMeta: metav1.ObjectMeta{
+															GenerateName: "test-",
+														},
+														Spec: TestJobPodSpec{
+															Containers: []TestJobContainer{
+																{
+																	Container: corev1.Container{
+																		Name:    "key",
+																		Image:   "alpine",
+																		Command: []string{"sh", "-c"},
+																		Args: []string{
+																			`echo '{"name":"A","env":[{"name":"EXPECTED","value":"A"}],"elapsedTimeSec":10,"resources":{"limits":{"cpu":"1"}}}'; echo '{"name":"B","env":[{"name":"EXPECTED","value":"B"}]}'`,
+																		},
+																	},
+																},
 															},
 														},
 													},
+													Format: StrategyDynamicKeyFormatJSON,
 												},
 											},
 										},
 									},
 								},
 								Scheduler: Scheduler{
-									MaxContainersPerPod:    10,
-									MaxConcurrentNumPerPod: 10,
+									MaxContainersPerPod:    2,
+									MaxConcurrentNumPerPod: 2,
 								},
 							},
 							Template: TestJobTemplateSpec{
 								ObjectMeta: metav1.ObjectMeta{
-									Name: "test",
+									GenerateName: "test-",
 								},
 								Spec: TestJobPodSpec{
 									Containers: []TestJobContainer{
 										{
 											Container: corev1.Container{
-												Name:       "test",
-												Image:      "alpine",
-												Command:    []string{"sh", "-c"},
-												Args:       []string{"echo $TEST"},
-												WorkingDir: filepath.Join("/", "work"),
+												Name:    "test",
+												Image:   "alpine",
+												Command: []string{"sh", "-c"},
+												Args:    []string{`test -n "$VERSION" && test "$PACKAGE" = "$EXPECTED"`},
 											},
 										},
 									},
@@ -439,91 +1864,211 @@ func TestRunner(t *testing.T) {
 							},
 						},
 					},
-				}); err == nil {
-					t.Fatal("expected error")
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if report.TotalNum != 4 {
+					t.Fatalf("failed to get total num: expected 4 but got %d", report.TotalNum)
+				}
+				if report.SuccessNum != 4 {
+					t.Fatalf("failed to get success num: expected 4 but got %d", report.SuccessNum)
 				}
 			})
 		}
 	})
-	t.Run("dynamic key based multiple tasks", func(t *testing.T) {
+	t.Run("impact keys", func(t *testing.T) {
+		// the upstream repository has the feature branch that changes pkg/a based on master branch.
+		upstream, err := os.MkdirTemp("", "upstream")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(upstream)
+		if err := os.MkdirAll(filepath.Join(upstream, "pkg", "a"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(upstream, "pkg", "a", "a.go"), []byte("package a"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		for _, args := range [][]string{
+			{"init", "-b", "master"},
+			{"config", "user.name", "kubetest"},
+			{"config", "user.email", "user@kubetest.com"},
+			{"commit", "--allow-empty", "-m", "initial commit"},
+			{"checkout", "-b", "feature"},
+			{"add", "."},
+			{"commit", "-m", "change pkg/a"},
+			{"checkout", "master"},
+		} {
+			if _, err := gitOutput(upstream, args...); err != nil {
+				t.Fatal(err)
+			}
+		}
 		for _, runMode := range getRunModes() {
 			t.Run(runMode.String(), func(t *testing.T) {
 				runner := NewRunner(getConfig(), runMode)
 				runner.SetLogger(NewLogger(os.Stdout, LogLevelDebug))
-				if _, err := runner.Run(context.Background(), TestJob{
+				report, err := runner.Run(context.Background(), TestJob{
 					ObjectMeta: testjobObjectMeta(),
 					Spec: TestJobSpec{
-						Repos: testRepos(),
+						Repos: []RepositorySpec{
+							{
+								Name: "repo",
+								Value: Repository{
+									URL:    upstream,
+									Branch: "feature",
+									Merge:  &MergeSpec{Base: "master"},
+								},
+							},
+						},
 						MainStep: MainStep{
 							Strategy: &Strategy{
 								Key: StrategyKeySpec{
 									Env: "TEST",
 									Source: StrategyKeySource{
-										Dynamic: &StrategyDynamicKeySource{
-											Template: TestJobTemplateSpec{
-												ObjectMeta: metav1.ObjectMeta{
+										Static: []string{"A", "B", "C"},
+									},
+								},
+								Scheduler: Scheduler{
+									MaxContainersPerPod:    10,
+									MaxConcurrentNumPerPod: 10,
+								},
+								Impact: &StrategyImpact{
+									Repo: "repo",
+									Rules: []StrategyImpactRule{
+										{Path: "pkg/a/**", Keys: []string{"A"}},
+										{Path: "pkg/b/**", Keys: []string{"B"}},
+									},
+									Fallback: []string{"C"},
+								},
+							},
+							Template: TestJobTemplateSpec{
+								ObjectMeta: metav1.ObjectMeta{
+									GenerateName: "test-",
+								},
+								Spec: TestJobPodSpec{
+									Containers: []TestJobContainer{
+										{
+											Container: corev1.Container{
+												Name:    "test",
+												Image:   "alpine",
+												Command: []string{"sh", "-c"},
+												Args:    []string{"echo $TEST"},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if report.TotalNum != 2 {
+					t.Fatalf("failed to get total num: expected 2 but got %d", report.TotalNum)
+				}
+				names := map[string]struct{}{}
+				for _, detail := range report.Details {
+					names[detail.Name] = struct{}{}
+				}
+				for _, name := range []string{"A", "C"} {
+					if _, exists := names[name]; !exists {
+						t.Fatalf("failed to find impacted key %s in report details: %v", name, report.Details)
+					}
+				}
+			})
+		}
+	})
+	t.Run("artifact keys", func(t *testing.T) {
+		for _, runMode := range getRunModes() {
+			t.Run(runMode.String(), func(t *testing.T) {
+				if runMode == RunModeDryRun {
+					// skip because dry-run mode doesn't create artifacts
+					return
+				}
+				runner := NewRunner(getConfig(), runMode)
+				runner.SetLogger(NewLogger(os.Stdout, LogLevelDebug))
+				report, err := runner.Run(context.Background(), TestJob{
+					ObjectMeta: testjobObjectMeta(),
+					Spec: TestJobSpec{
+						PreSteps: []PreStep{
+							{
+								Name: "list",
+								Template: TestJobTemplateSpec{
+									ObjectMeta: metav1.ObjectMeta{
+										GenerateName: "list-",
+									},
+									Spec: TestJobPodSpec{
+										Artifacts: []ArtifactSpec{
+											{
+												Name: "test-keys",
+												Container: ArtifactContainer{
 													Name: "list",
+													Path: filepath.Join("/", "work", "keys.txt"),
 												},
-												Spec: TestJobPodSpec{
-													Containers: []TestJobContainer{
-														{
-															Container: corev1.Container{
-																Name:    "list",
-																Image:   "alpine",
-																Command: []string{"sh", "-c"},
-																Args: []string{
-																	fmt.Sprintf(
-																		`echo "%s"`,
-																		string([]byte{
-																			'A', '\n',
-																			'B', '\n',
-																			'C', '\n',
-																			'D',
-																		}),
-																	),
-																},
-															},
-														},
-													},
+											},
+										},
+										Containers: []TestJobContainer{
+											{
+												Container: corev1.Container{
+													Name:       "list",
+													Image:      "alpine",
+													Command:    []string{"sh", "-c"},
+													Args:       []string{`printf "A\nB\nC\n" > keys.txt`},
+													WorkingDir: filepath.Join("/", "work"),
 												},
 											},
 										},
 									},
 								},
+							},
+						},
+						MainStep: MainStep{
+							Strategy: &Strategy{
+								Key: StrategyKeySpec{
+									Env: "TEST",
+									Source: StrategyKeySource{
+										Artifact: &StrategyArtifactKeySource{
+											Name:   "test-keys",
+											Filter: "[AC]",
+										},
+									},
+								},
 								Scheduler: Scheduler{
-									MaxContainersPerPod:    10,
-									MaxConcurrentNumPerPod: 10,
+									MaxContainersPerPod:    2,
+									MaxConcurrentNumPerPod: 2,
 								},
 							},
 							Template: TestJobTemplateSpec{
 								ObjectMeta: metav1.ObjectMeta{
-									Name: "test",
+									GenerateName: "test-",
 								},
 								Spec: TestJobPodSpec{
 									Containers: []TestJobContainer{
 										{
 											Container: corev1.Container{
-												Name:       "test",
-												Image:      "alpine",
-												Command:    []string{"sh", "-c"},
-												Args:       []string{"echo $TEST"},
-												WorkingDir: filepath.Join("/", "work"),
-												VolumeMounts: []corev1.VolumeMount{
-													testRepoVolumeMount(),
-												},
+												Name:    "test",
+												Image:   "alpine",
+												Command: []string{"sh", "-c"},
+												Args:    []string{`test -n "$TEST"`},
 											},
 										},
 									},
-									Volumes: []TestJobVolume{
-										testRepoVolume(),
-									},
 								},
 							},
 						},
 					},
-				}); err != nil {
+				})
+				if err != nil {
 					t.Fatal(err)
 				}
+				if report.TotalNum != 2 {
+					t.Fatalf("failed to get total num: expected 2 but got %d", report.TotalNum)
+				}
+				if report.SuccessNum != 2 {
+					t.Fatalf("failed to get success num: expected 2 but got %d", report.SuccessNum)
+				}
 			})
 		}
 	})
@@ -737,6 +2282,77 @@ func TestRunner(t *testing.T) {
 			})
 		}
 	})
+	t.Run("conditional post steps", func(t *testing.T) {
+		postStep := func(name string, when PostStepCondition, command string) PostStep {
+			return PostStep{
+				Name: name,
+				When: when,
+				Template: TestJobTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{
+						GenerateName: name + "-",
+					},
+					Spec: TestJobPodSpec{
+						Containers: []TestJobContainer{
+							{
+								Container: corev1.Container{
+									Name:    name,
+									Image:   "alpine",
+									Command: []string{"sh", "-c"},
+									Args:    []string{command},
+								},
+							},
+						},
+					},
+				},
+			}
+		}
+		for _, runMode := range getRunModes() {
+			t.Run(runMode.String(), func(t *testing.T) {
+				if runMode == RunModeDryRun {
+					// skip because the command always succeeds in dry-run mode
+					t.Skip()
+				}
+				runner := NewRunner(getConfig(), runMode)
+				runner.SetLogger(NewLogger(os.Stdout, LogLevelDebug))
+				report, err := runner.Run(context.Background(), TestJob{
+					ObjectMeta: testjobObjectMeta(),
+					Spec: TestJobSpec{
+						MainStep: MainStep{
+							Template: TestJobTemplateSpec{
+								ObjectMeta: metav1.ObjectMeta{
+									GenerateName: "test-",
+								},
+								Spec: TestJobPodSpec{
+									Containers: []TestJobContainer{
+										{
+											Container: corev1.Container{
+												Name:    "test",
+												Image:   "alpine",
+												Command: []string{"sh", "-c"},
+												Args:    []string{"exit 1"},
+											},
+										},
+									},
+								},
+							},
+						},
+						PostSteps: []PostStep{
+							// the step that fails must be skipped because the test failed.
+							postStep("on-success", PostStepConditionOnSuccess, "exit 1"),
+							postStep("on-failure", PostStepConditionOnFailure, "echo failure"),
+							postStep("always", PostStepConditionAlways, "echo always"),
+						},
+					},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if report.Status != ResultStatusFailure {
+					t.Fatalf("failed to get status: expected failure but got %s", report.Status)
+				}
+			})
+		}
+	})
 	t.Run("use kubetest-agent", func(t *testing.T) {
 		for _, runMode := range getRunModes() {
 			if runMode != RunModeKubernetes {
@@ -940,3 +2556,27 @@ func TestRunner(t *testing.T) {
 	})
 
 }
+
+func TestPostStepCondition(t *testing.T) {
+	stepErr := errors.New("error")
+	for _, test := range []struct {
+		when     PostStepCondition
+		status   ResultStatus
+		err      error
+		expected bool
+	}{
+		{when: "", status: ResultStatusSuccess, expected: true},
+		{when: "", status: ResultStatusFailure, expected: true},
+		{when: "", status: ResultStatusError, err: stepErr, expected: false},
+		{when: PostStepConditionAlways, status: ResultStatusError, err: stepErr, expected: true},
+		{when: PostStepConditionOnSuccess, status: ResultStatusSuccess, expected: true},
+		{when: PostStepConditionOnSuccess, status: ResultStatusFailure, expected: false},
+		{when: PostStepConditionOnFailure, status: ResultStatusSuccess, expected: false},
+		{when: PostStepConditionOnFailure, status: ResultStatusFailure, expected: true},
+		{when: PostStepConditionOnFailure, status: ResultStatusError, err: stepErr, expected: true},
+	} {
+		if actual := test.when.shouldRun(test.status, test.err); actual != test.expected {
+			t.Fatalf("unexpected condition of %q by status %s and error %v: expected %v but got %v", test.when, test.status, test.err, test.expected, actual)
+		}
+	}
+}
@@ -4,113 +4,763 @@
 package v1
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
-	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	defaultMatrixKeyEnv = "KUBETEST_STRATEGY_KEY"
+	defaultKeyDelimiter = " "
 )
 
 type TaskScheduler struct {
-	step    MainStep
+	step    Step
 	builder *TaskBuilder
+	// extraEnv env values passed with the key env for each key ( e.g. env values of all dimensions of matrix, env of structured dynamic key ).
+	extraEnv map[string][]corev1.EnvVar
+	// keyResources resource requirements of the container for each key specified by structured dynamic key.
+	keyResources map[string]*corev1.ResourceRequirements
+	// keyElapsedTime expected elapsed time in milliseconds for each key specified by structured dynamic key.
+	keyElapsedTime map[string]int64
+	// quarantine patterns of quarantined keys. If nil, there is no quarantined key.
+	quarantine *keyQuarantine
 }
 
 func NewTaskScheduler(step MainStep) *TaskScheduler {
+	return NewTaskSchedulerByStep(&step)
+}
+
+// NewTaskSchedulerByStep creates the scheduler for any step. The keys are distributed by the strategy of the step.
+func NewTaskSchedulerByStep(step Step) *TaskScheduler {
 	return &TaskScheduler{
-		step: step,
+		step:           step,
+		extraEnv:       map[string][]corev1.EnvVar{},
+		keyResources:   map[string]*corev1.ResourceRequirements{},
+		keyElapsedTime: map[string]int64{},
 	}
 }
 
+// DynamicKey the key object of dynamic key task's output when format is json.
+type DynamicKey struct {
+	// Name strategy key name.
+	Name string `json:"name"`
+	// Env env values passed to the container with the key env.
+	Env []corev1.EnvVar `json:"env,omitempty"`
+	// ElapsedTimeSec expected elapsed time of the key. It's used as the weight to distribute keys.
+	ElapsedTimeSec int64 `json:"elapsedTimeSec,omitempty"`
+	// Resources resource requirements of the container that runs the key.
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
 type StrategyKey struct {
 	ConcurrentIdx    uint32
 	Keys             []string
 	Env              string
 	SubTaskScheduler *SubTaskScheduler
-	OnFinishSubTask  func(*SubTask)
+	OnFinishSubTask  func(*SubTask, *SubTaskResult)
+	// Queue shared key queue for queue mode. If specified, Keys is empty and WorkerNum containers pull keys from the queue.
+	Queue     *KeyQueue
+	WorkerNum int
+	// ExtraEnv env values passed with the key env for each key.
+	ExtraEnv map[string][]corev1.EnvVar
+	// Resources resource requirements of the container for each key. If the key doesn't exist, uses the resources of the template.
+	Resources map[string]*corev1.ResourceRequirements
+	// Batch if specified, each key of Keys or Queue is the multiple keys joined by delimiter.
+	Batch *KeyBatch
+	// KeyTimeout time limit of each key. If zero, there is no limit.
+	KeyTimeout time.Duration
+}
+
+// withKeys returns the copy of the strategy key that runs only the specified keys.
+func (k *StrategyKey) withKeys(keys []string) *StrategyKey {
+	key := *k
+	key.Keys = keys
+	return &key
+}
+
+// EnvVars returns env values passed to the container that runs the key.
+func (k *StrategyKey) EnvVars(key string) []corev1.EnvVar {
+	envs := []corev1.EnvVar{{Name: k.Env, Value: key}}
+	return append(envs, k.ExtraEnv[key]...)
+}
+
+// KeyBatch packs multiple strategy keys into one container.
+type KeyBatch struct {
+	// Delim delimiter to join keys.
+	Delim string
+	// ResultMarker prefix of the output line that reports the result of each key.
+	ResultMarker string
+}
+
+func newKeyBatch(scheduler Scheduler) *KeyBatch {
+	if scheduler.KeysPerContainer <= 1 {
+		return nil
+	}
+	delim := scheduler.KeyDelimiter
+	if delim == "" {
+		delim = defaultKeyDelimiter
+	}
+	return &KeyBatch{
+		Delim:        delim,
+		ResultMarker: scheduler.ResultMarker,
+	}
+}
+
+// Keys splits the batched key into the original keys.
+func (b *KeyBatch) Keys(name string) []string {
+	if b == nil {
+		return []string{name}
+	}
+	return strings.Split(name, b.Delim)
+}
+
+// join joins keys into one batched key.
+func (b *KeyBatch) join(keys []string) string {
+	if b == nil {
+		return strings.Join(keys, "")
+	}
+	return strings.Join(keys, b.Delim)
+}
+
+// batchKeys packs every keysPerContainer keys into one key joined by delimiter.
+func (b *KeyBatch) batchKeys(keys []string, keysPerContainer int) ([]string, error) {
+	if b == nil {
+		return keys, nil
+	}
+	batchedKeys := []string{}
+	for _, partition := range partitionKeysByOrder(keys, keysPerContainer) {
+		for _, key := range partition {
+			if strings.Contains(key, b.Delim) {
+				return nil, fmt.Errorf("kubetest: strategy key %q contains the key delimiter %q", key, b.Delim)
+			}
+		}
+		batchedKeys = append(batchedKeys, strings.Join(partition, b.Delim))
+	}
+	return batchedKeys, nil
+}
+
+// elapsedTime returns the expected elapsed time of each batched key as the sum of its keys.
+func (b *KeyBatch) elapsedTime(keys, batchedKeys []string, nameToElapsedTime map[string]int64) map[string]int64 {
+	if b == nil || nameToElapsedTime == nil {
+		return nameToElapsedTime
+	}
+	expectedElapsedTime := elapsedTimeEstimator(keys, nameToElapsedTime)
+	batchToElapsedTime := make(map[string]int64, len(batchedKeys))
+	for _, batchedKey := range batchedKeys {
+		for _, key := range b.Keys(batchedKey) {
+			batchToElapsedTime[batchedKey] += expectedElapsedTime(key)
+		}
+	}
+	return batchToElapsedTime
+}
+
+// parseResults returns the status of each key reported by the output line that starts with ResultMarker.
+func (b *KeyBatch) parseResults(out []byte) map[string]TaskResultStatus {
+	keyToStatus := map[string]TaskResultStatus{}
+	if b == nil || b.ResultMarker == "" {
+		return keyToStatus
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, b.ResultMarker) {
+			continue
+		}
+		fields := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(line, b.ResultMarker)), " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(fields[1])
+		switch fields[0] {
+		case TaskResultSuccess.String():
+			keyToStatus[key] = TaskResultSuccess
+		case TaskResultFailure.String():
+			keyToStatus[key] = TaskResultFailure
+		}
+	}
+	return keyToStatus
+}
+
+// KeyQueue is the queue of strategy keys shared by all worker containers in queue mode.
+type KeyQueue struct {
+	keys   []string
+	keyNum int
+	mu     sync.Mutex
+}
+
+func NewKeyQueue(keys []string) *KeyQueue {
+	return &KeyQueue{
+		keys:   keys,
+		keyNum: len(keys),
+	}
+}
+
+// Pop returns the next key. If the queue is empty, returns false.
+func (q *KeyQueue) Pop() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.keys) == 0 {
+		return "", false
+	}
+	key := q.keys[0]
+	q.keys = q.keys[1:]
+	return key, true
+}
+
+// Push adds keys to the end of the queue to run them again.
+func (q *KeyQueue) Push(keys ...string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.keys = append(q.keys, keys...)
+}
+
+// KeyNum returns the number of keys initially added to the queue. If keys are batched, each key of the batch is counted.
+func (q *KeyQueue) KeyNum() int {
+	return q.keyNum
 }
 
 func (s *TaskScheduler) Schedule(ctx context.Context, builder *TaskBuilder) (*TaskGroup, error) {
-	if s.step.Strategy == nil {
-		task, err := builder.Build(ctx, &s.step)
+	if s.step.GetStrategy() == nil {
+		task, err := builder.Build(ctx, s.step)
 		if err != nil {
 			return nil, err
 		}
 		return NewTaskGroup([]*Task{task}), nil
 	}
-	strategy := s.step.Strategy
-	keys, err := s.getScheduleKeys(ctx, builder, strategy.Key.Source)
+	keys, err := s.getStrategyKeys(ctx, builder, s.step.GetStrategy().Key)
 	if err != nil {
 		return nil, err
 	}
+	if quarantine := s.step.GetStrategy().Quarantine; quarantine != nil {
+		q, err := s.loadQuarantine(ctx, builder, quarantine)
+		if err != nil {
+			return nil, err
+		}
+		s.quarantine = q
+	}
+	if impact := s.step.GetStrategy().Impact; impact != nil {
+		impactKeys, err := s.impactKeys(ctx, builder, keys, impact)
+		if err != nil {
+			return nil, err
+		}
+		keys = impactKeys
+	}
+	if shard := s.step.GetStrategy().Shard; shard != nil {
+		shardKeys := shardKeys(keys, shard)
+		LoggerFromContext(ctx).Info("shard %d/%d: run %d keys of %d keys", shard.Index, shard.Total, len(shardKeys), len(keys))
+		keys = shardKeys
+	}
+	return s.ScheduleWithKeys(ctx, builder, keys)
+}
+
+// ScheduleWithKeys schedules tasks for the specified strategy keys instead of the keys got from strategy.key.source.
+func (s *TaskScheduler) ScheduleWithKeys(ctx context.Context, builder *TaskBuilder, keys []string) (*TaskGroup, error) {
+	strategy := s.step.GetStrategy()
+	if strategy == nil {
+		return nil, fmt.Errorf("kubetest: failed to schedule with keys. strategy is undefined")
+	}
+	return s.scheduleWithKeys(ctx, builder, keys, strategy.Repeat)
+}
+
+// RescheduleKeys schedules tasks to re-run the specified keys for retest or flaky detection.
+// Unlike ScheduleWithKeys, each key runs once even if strategy.repeat is specified.
+func (s *TaskScheduler) RescheduleKeys(ctx context.Context, builder *TaskBuilder, keys []string) (*TaskGroup, error) {
+	if s.step.GetStrategy() == nil {
+		return nil, fmt.Errorf("kubetest: failed to reschedule keys. strategy is undefined")
+	}
+	return s.scheduleWithKeys(ctx, builder, keys, 1)
+}
+
+// scheduleWithKeys schedules tasks that run each key the number of repeat times.
+func (s *TaskScheduler) scheduleWithKeys(ctx context.Context, builder *TaskBuilder, keys []string, repeat int) (*TaskGroup, error) {
+	strategy := s.step.GetStrategy()
+	keys = repeatKeys(keys, repeat)
 	subTaskScheduler := NewSubTaskScheduler(strategy.Scheduler.MaxConcurrentNumPerPod)
-	maxContainers := uint32(strategy.Scheduler.MaxContainersPerPod)
+	timeout, err := parseDuration(s.step.GetTimeout())
+	if err != nil {
+		return nil, err
+	}
+	keyTimeout, err := parseDuration(strategy.KeyTimeout)
+	if err != nil {
+		return nil, err
+	}
+	nameToElapsedTime, err := s.previousElapsedTime(ctx, strategy.Scheduler)
+	if err != nil {
+		return nil, err
+	}
+	nameToElapsedTime = s.expectedElapsedTime(nameToElapsedTime)
+	batch := newKeyBatch(strategy.Scheduler)
+	if batch != nil {
+		for _, key := range keys {
+			if len(s.extraEnv[key]) > 0 || s.keyResources[key] != nil {
+				return nil, fmt.Errorf("kubetest: strategy key %q has env or resources. it cannot be used with strategy.scheduler.keysPerContainer", key)
+			}
+		}
+	}
+	batchedKeys, err := batch.batchKeys(keys, strategy.Scheduler.KeysPerContainer)
+	if err != nil {
+		return nil, err
+	}
+	nameToElapsedTime = batch.elapsedTime(keys, batchedKeys, nameToElapsedTime)
+	partitions := partitionKeys(batchedKeys, strategy.Scheduler.MaxContainersPerPod, nameToElapsedTime)
+	var queue *KeyQueue
+	if strategy.Scheduler.Mode == SchedulerModeQueue {
+		queue = NewKeyQueue(sortKeysByElapsedTime(batchedKeys, nameToElapsedTime))
+		queue.keyNum = len(keys)
+		if len(s.keyResources) > 0 {
+			LoggerFromContext(ctx).Warn("resources of strategy keys are ignored in queue mode because the worker container runs any key")
+		}
+	}
 
 	var (
 		finishedKeyNum uint32
 		keyNum         uint32 = uint32(len(keys))
 		onFinishMu     sync.Mutex
+		taskGroup      *TaskGroup
 	)
-	if keyNum <= maxContainers {
-		task, err := builder.BuildWithKey(ctx, &s.step, &StrategyKey{
-			ConcurrentIdx:    0,
-			Keys:             keys,
+	onFinishSubTask := func(subTask *SubTask, result *SubTaskResult) {
+		onFinishMu.Lock()
+		defer onFinishMu.Unlock()
+		// the failure of quarantined keys doesn't block the others.
+		if strategy.FailFast && result.Status.failed() && !s.quarantine.quarantinedAll(batch.Keys(subTask.Name)) {
+			LoggerFromContext(ctx).Warn("%s failed. cancel the remaining keys by failFast", subTask.Name)
+			taskGroup.Cancel()
+		}
+		finishedKeyNum += uint32(len(batch.Keys(subTask.Name)))
+		runningPodNum, queuedPodNum := taskGroup.PodNum()
+		LoggerFromContext(ctx).Info(
+			"%d/%d (%f%%) finished. ( running pods: %d, queued pods: %d )",
+			finishedKeyNum, keyNum, (float32(finishedKeyNum)/float32(keyNum))*100,
+			runningPodNum, queuedPodNum,
+		)
+	}
+	tasks := make([]*Task, 0, len(partitions))
+	sum := uint32(0)
+	for i, taskKeys := range partitions {
+		strategyKey := &StrategyKey{
+			ConcurrentIdx:    uint32(i),
+			Keys:             taskKeys,
 			SubTaskScheduler: subTaskScheduler,
-			Env:              strategy.Key.Env,
-			OnFinishSubTask: func(_ *SubTask) {
-				onFinishMu.Lock()
-				defer onFinishMu.Unlock()
-				finishedKeyNum++
-				LoggerFromContext(ctx).Info(
-					"%d/%d (%f%%) finished.",
-					finishedKeyNum, keyNum, (float32(finishedKeyNum)/float32(keyNum))*100,
-				)
-			},
-		})
+			Env:              s.keyEnv(),
+			OnFinishSubTask:  onFinishSubTask,
+			ExtraEnv:         s.extraEnv,
+			Resources:        s.keyResources,
+			Batch:            batch,
+			KeyTimeout:       keyTimeout,
+		}
+		if queue != nil {
+			// keys are not bound to the pod. the pod runs the same number of workers as concurrent containers.
+			strategyKey.Keys = nil
+			strategyKey.Queue = queue
+			strategyKey.WorkerNum = subTaskScheduler.getConcurrentNum(len(taskKeys))
+		}
+		task, err := builder.BuildWithKey(ctx, s.step, strategyKey)
 		if err != nil {
 			return nil, err
 		}
-		return NewTaskGroup([]*Task{task}), nil
+		tasks = append(tasks, task)
+		sum += uint32(len(taskKeys))
 	}
-	concurrent := keyNum / maxContainers
-	tasks := []*Task{}
-	sum := uint32(0)
-	for i := uint32(0); i <= concurrent; i++ {
-		var taskKeys []string
-		if i == concurrent {
-			taskKeys = keys[sum:]
-		} else {
-			taskKeys = keys[sum : sum+maxContainers]
-		}
-		taskNum := uint32(len(taskKeys))
-		if taskNum == 0 {
-			// if 'keyNum % maxContaienrs' is zero, taskKeys goes to zero in the last loop.
+	if uint32(len(batchedKeys)) != sum {
+		return nil, fmt.Errorf("kubetest: failed to schedule: required key num %d but scheduled key num %d", len(batchedKeys), sum)
+	}
+	taskGroup = NewTaskGroup(tasks)
+	taskGroup.maxConcurrentNum = strategy.Scheduler.MaxPodsPerStep
+	taskGroup.timeout = timeout
+	taskGroup.quarantine = s.quarantine
+	taskGroup.repeat = repeat
+	return taskGroup, nil
+}
+
+// keyQuarantine holds the patterns of quarantined keys.
+type keyQuarantine struct {
+	patterns []*regexp.Regexp
+}
+
+func (q *keyQuarantine) quarantined(key string) bool {
+	if q == nil {
+		return false
+	}
+	for _, pattern := range q.patterns {
+		if pattern.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *keyQuarantine) quarantinedAll(keys []string) bool {
+	if q == nil {
+		return false
+	}
+	for _, key := range keys {
+		if !q.quarantined(key) {
+			return false
+		}
+	}
+	return true
+}
+
+// loadQuarantine reads the patterns of quarantined keys from all sources of strategy.quarantine.
+func (s *TaskScheduler) loadQuarantine(ctx context.Context, builder *TaskBuilder, quarantine *StrategyQuarantine) (*keyQuarantine, error) {
+	patterns := append([]string{}, quarantine.Keys...)
+	if source := quarantine.ConfigMap; source != nil {
+		value, err := builder.mgr.ConfigMapValue(ctx, source.Name, source.Key)
+		if err != nil {
+			return nil, err
+		}
+		configMapPatterns, err := s.parseKeys([]byte(value), source.Delim, source.Filter, StrategyDynamicKeyFormatText)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, configMapPatterns...)
+	}
+	if source := quarantine.Repo; source != nil {
+		path, err := builder.mgr.RepositoryFilePathByName(source.Name, source.Path)
+		if err != nil {
+			return nil, err
+		}
+		out, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("kubetest: failed to read quarantined keys from %s in repository %s: %w", source.Path, source.Name, err)
+		}
+		repoPatterns, err := s.parseKeys(out, source.Delim, source.Filter, StrategyDynamicKeyFormatText)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, repoPatterns...)
+	}
+	q := &keyQuarantine{}
+	for _, pattern := range patterns {
+		re, err := globRegexp(strings.TrimSpace(pattern))
+		if err != nil {
+			return nil, err
+		}
+		q.patterns = append(q.patterns, re)
+	}
+	LoggerFromContext(ctx).Info("quarantine %d key patterns", len(q.patterns))
+	return q, nil
+}
+
+// impactKeys returns the keys affected by the changed files of the merged repository.
+// If the changed files are unknown ( e.g. reuse an already cloned directory ), returns all keys.
+func (s *TaskScheduler) impactKeys(ctx context.Context, builder *TaskBuilder, keys []string, impact *StrategyImpact) ([]string, error) {
+	changedFiles, err := builder.mgr.ChangedFilesByRepoName(impact.Repo)
+	if err != nil {
+		LoggerFromContext(ctx).Warn("couldn't select keys by impact. run all keys: %s", err.Error())
+		return keys, nil
+	}
+	selectedKeys, err := selectImpactKeys(keys, changedFiles, impact)
+	if err != nil {
+		return nil, err
+	}
+	LoggerFromContext(ctx).Info("impact: %d changed files affect %d keys of %d keys", len(changedFiles), len(selectedKeys), len(keys))
+	return selectedKeys, nil
+}
+
+// selectImpactKeys returns the keys that match the key patterns of the rules matched by changed files or fallback patterns.
+// The order of keys is kept.
+func selectImpactKeys(keys, changedFiles []string, impact *StrategyImpact) ([]string, error) {
+	patterns := []*regexp.Regexp{}
+	for _, pattern := range impact.Fallback {
+		re, err := globRegexp(pattern)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, re)
+	}
+	for _, rule := range impact.Rules {
+		pathPattern, err := globRegexp(rule.Path)
+		if err != nil {
+			return nil, err
+		}
+		matched := false
+		for _, file := range changedFiles {
+			if pathPattern.MatchString(file) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
 			continue
 		}
-		task, err := builder.BuildWithKey(ctx, &s.step, &StrategyKey{
-			ConcurrentIdx:    i,
-			Keys:             taskKeys,
-			SubTaskScheduler: subTaskScheduler,
-			Env:              strategy.Key.Env,
-			OnFinishSubTask: func(_ *SubTask) {
-				atomic.AddUint32(&finishedKeyNum, 1)
-				LoggerFromContext(ctx).Info(
-					"%d/%d (%f%%) finished.",
-					finishedKeyNum, keyNum, (float32(finishedKeyNum)/float32(keyNum))*100,
-				)
-			},
-		})
+		for _, pattern := range rule.Keys {
+			re, err := globRegexp(pattern)
+			if err != nil {
+				return nil, err
+			}
+			patterns = append(patterns, re)
+		}
+	}
+	selectedKeys := []string{}
+	for _, key := range keys {
+		for _, pattern := range patterns {
+			if pattern.MatchString(key) {
+				selectedKeys = append(selectedKeys, key)
+				break
+			}
+		}
+	}
+	return selectedKeys, nil
+}
+
+// shardKeys returns the keys belonging to the shard.
+// The key is assigned to the shard by its hash value, so the result doesn't depend on the order of keys.
+func shardKeys(keys []string, shard *Shard) []string {
+	filtered := []string{}
+	for _, key := range keys {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(key))
+		if int(h.Sum32()%uint32(shard.Total)) == shard.Index {
+			filtered = append(filtered, key)
+		}
+	}
+	return filtered
+}
+
+// previousElapsedTime returns the elapsed time of each key in the previous report in milliseconds.
+// If previous report isn't specified, returns nil.
+func (s *TaskScheduler) previousElapsedTime(ctx context.Context, scheduler Scheduler) (map[string]int64, error) {
+	if scheduler.PreviousReport == "" {
+		return nil, nil
+	}
+	report, err := LoadReport(scheduler.PreviousReport)
+	if err != nil {
+		return nil, err
+	}
+	LoggerFromContext(ctx).Info("distribute keys by elapsed time of previous report %s", scheduler.PreviousReport)
+	return report.ElapsedTimeMsecByName(), nil
+}
+
+// expectedElapsedTime adds the elapsed time specified by structured dynamic key to the elapsed time of the previous report.
+// The elapsed time of the previous report takes precedence because it's the actual value.
+func (s *TaskScheduler) expectedElapsedTime(nameToElapsedTime map[string]int64) map[string]int64 {
+	if len(s.keyElapsedTime) == 0 {
+		return nameToElapsedTime
+	}
+	merged := make(map[string]int64, len(s.keyElapsedTime)+len(nameToElapsedTime))
+	for name, elapsedTime := range s.keyElapsedTime {
+		merged[name] = elapsedTime
+	}
+	for name, elapsedTime := range nameToElapsedTime {
+		merged[name] = elapsedTime
+	}
+	return merged
+}
+
+// partitionKeys splits keys into the keys for each pod.
+// If the elapsed time of previous run is specified, keys are balanced across pods by it.
+func partitionKeys(keys []string, maxContainers int, nameToElapsedTime map[string]int64) [][]string {
+	if nameToElapsedTime == nil {
+		return partitionKeysByOrder(keys, maxContainers)
+	}
+	return partitionKeysByElapsedTime(keys, maxContainers, nameToElapsedTime)
+}
+
+// sortKeysByElapsedTime sorts keys so that the worker pulls the key that takes a long time first.
+// If the elapsed time of previous run isn't specified, keeps the order of keys.
+func sortKeysByElapsedTime(keys []string, nameToElapsedTime map[string]int64) []string {
+	sortedKeys := make([]string, len(keys))
+	copy(sortedKeys, keys)
+	if nameToElapsedTime == nil {
+		return sortedKeys
+	}
+	expectedElapsedTime := elapsedTimeEstimator(keys, nameToElapsedTime)
+	sort.SliceStable(sortedKeys, func(i, j int) bool {
+		return expectedElapsedTime(sortedKeys[i]) > expectedElapsedTime(sortedKeys[j])
+	})
+	return sortedKeys
+}
+
+// elapsedTimeEstimator returns the function to get the expected elapsed time of the key.
+// The elapsed time of the key that doesn't exist in nameToElapsedTime is regarded as the average.
+func elapsedTimeEstimator(keys []string, nameToElapsedTime map[string]int64) func(string) int64 {
+	var (
+		knownNum         int64
+		totalElapsedTime int64
+	)
+	for _, key := range keys {
+		if elapsedTime, exists := nameToElapsedTime[key]; exists {
+			totalElapsedTime += elapsedTime
+			knownNum++
+		}
+	}
+	var averageElapsedTime int64
+	if knownNum > 0 {
+		averageElapsedTime = totalElapsedTime / knownNum
+	}
+	return func(key string) int64 {
+		if elapsedTime, exists := nameToElapsedTime[key]; exists {
+			return elapsedTime
+		}
+		return averageElapsedTime
+	}
+}
+
+func partitionKeysByOrder(keys []string, maxContainers int) [][]string {
+	partitions := [][]string{}
+	for start := 0; start < len(keys); start += maxContainers {
+		end := start + maxContainers
+		if end > len(keys) {
+			end = len(keys)
+		}
+		partitions = append(partitions, keys[start:end])
+	}
+	return partitions
+}
+
+// partitionKeysByElapsedTime assigns keys to pods by LPT ( Longest Processing Time first ) algorithm.
+// The number of pods is the same as partitionKeysByOrder, and each key is assigned to the pod with the least total elapsed time.
+func partitionKeysByElapsedTime(keys []string, maxContainers int, nameToElapsedTime map[string]int64) [][]string {
+	if len(keys) == 0 {
+		return [][]string{}
+	}
+	expectedElapsedTime := elapsedTimeEstimator(keys, nameToElapsedTime)
+	sortedKeys := sortKeysByElapsedTime(keys, nameToElapsedTime)
+
+	podNum := (len(keys) + maxContainers - 1) / maxContainers
+	partitions := make([][]string, podNum)
+	loads := make([]int64, podNum)
+	for _, key := range sortedKeys {
+		target := -1
+		for idx := range partitions {
+			if len(partitions[idx]) >= maxContainers {
+				continue
+			}
+			if target < 0 || loads[idx] < loads[target] {
+				target = idx
+			}
+		}
+		partitions[target] = append(partitions[target], key)
+		loads[target] += expectedElapsedTime(key)
+	}
+	return partitions
+}
+
+// keyEnv returns the env name for strategy key.
+func (s *TaskScheduler) keyEnv() string {
+	key := s.step.GetStrategy().Key
+	if key.Env == "" && len(key.Matrix) > 0 {
+		return defaultMatrixKeyEnv
+	}
+	return key.Env
+}
+
+func (s *TaskScheduler) getStrategyKeys(ctx context.Context, builder *TaskBuilder, spec StrategyKeySpec) ([]string, error) {
+	if len(spec.Matrix) > 0 {
+		return s.matrixKeys(ctx, builder, spec.Matrix)
+	}
+	return s.getScheduleKeys(ctx, builder, spec.Source)
+}
+
+// matrixKeys expands the dimensions of matrix into the cartesian product of them.
+// Each combined key is named by all pairs of env name and value ( e.g. GO_VERSION=1.16,TEST_PACKAGE=foo ).
+// If the value of dimension is structured dynamic key, its env, resources and elapsed time are also passed to the combined key.
+// The resources of the later dimension override the same resource of the earlier one, and the longest elapsed time is used as the weight.
+func (s *TaskScheduler) matrixKeys(ctx context.Context, builder *TaskBuilder, matrix []StrategyMatrixDimension) ([]string, error) {
+	type combination struct {
+		pairs       []corev1.EnvVar
+		env         []corev1.EnvVar
+		resources   *corev1.ResourceRequirements
+		elapsedTime int64
+	}
+	combinations := []combination{{}}
+	for _, dimension := range matrix {
+		values, err := s.getScheduleKeys(ctx, builder, dimension.Source)
 		if err != nil {
 			return nil, err
 		}
-		tasks = append(tasks, task)
-		sum += taskNum
+		next := make([]combination, 0, len(combinations)*len(values))
+		for _, c := range combinations {
+			for _, value := range values {
+				pair := corev1.EnvVar{Name: dimension.Env, Value: value}
+				pairs := make([]corev1.EnvVar, 0, len(c.pairs)+1)
+				pairs = append(append(pairs, c.pairs...), pair)
+				env := make([]corev1.EnvVar, 0, len(c.env)+1)
+				env = append(append(append(env, c.env...), pair), s.extraEnv[value]...)
+				elapsedTime := c.elapsedTime
+				if s.keyElapsedTime[value] > elapsedTime {
+					elapsedTime = s.keyElapsedTime[value]
+				}
+				next = append(next, combination{
+					pairs:       pairs,
+					env:         env,
+					resources:   mergeResources(c.resources, s.keyResources[value]),
+					elapsedTime: elapsedTime,
+				})
+			}
+		}
+		combinations = next
+	}
+	var (
+		extraEnv       = make(map[string][]corev1.EnvVar, len(combinations))
+		keyResources   = map[string]*corev1.ResourceRequirements{}
+		keyElapsedTime = map[string]int64{}
+		keys           = make([]string, 0, len(combinations))
+	)
+	for _, c := range combinations {
+		key := matrixKeyName(c.pairs)
+		if _, exists := extraEnv[key]; exists {
+			continue
+		}
+		extraEnv[key] = c.env
+		if c.resources != nil {
+			keyResources[key] = c.resources
+		}
+		if c.elapsedTime > 0 {
+			keyElapsedTime[key] = c.elapsedTime
+		}
+		keys = append(keys, key)
+	}
+	s.extraEnv = extraEnv
+	s.keyResources = keyResources
+	s.keyElapsedTime = keyElapsedTime
+	LoggerFromContext(ctx).Info("found %d matrix keys to start distributed task", len(keys))
+	return keys, nil
+}
+
+// mergeResources merges the resource requirements. The resources of src override the same resources of base.
+func mergeResources(base, src *corev1.ResourceRequirements) *corev1.ResourceRequirements {
+	if src == nil {
+		return base
+	}
+	if base == nil {
+		return src
+	}
+	merged := base.DeepCopy()
+	for name, quantity := range src.Limits {
+		if merged.Limits == nil {
+			merged.Limits = corev1.ResourceList{}
+		}
+		merged.Limits[name] = quantity
 	}
-	if keyNum != sum {
-		return nil, fmt.Errorf("kubetest: failed to schedule: required key num %d but scheduled key num %d", keyNum, sum)
+	for name, quantity := range src.Requests {
+		if merged.Requests == nil {
+			merged.Requests = corev1.ResourceList{}
+		}
+		merged.Requests[name] = quantity
 	}
-	return NewTaskGroup(tasks), nil
+	return merged
+}
+
+func matrixKeyName(env []corev1.EnvVar) string {
+	pairs := make([]string, 0, len(env))
+	for _, e := range env {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", e.Name, e.Value))
+	}
+	return strings.Join(pairs, ",")
 }
 
 func (s *TaskScheduler) getScheduleKeys(ctx context.Context, builder *TaskBuilder, source StrategyKeySource) ([]string, error) {
@@ -123,6 +773,12 @@ func (s *TaskScheduler) getScheduleKeys(ctx context.Context, builder *TaskBuilde
 		return source.Static, nil
 	case source.Dynamic != nil:
 		return s.dynamicKeys(ctx, builder, source.Dynamic)
+	case source.ConfigMap != nil:
+		return s.configMapKeys(ctx, builder, source.ConfigMap)
+	case source.Repo != nil:
+		return s.repositoryKeys(ctx, builder, source.Repo)
+	case source.Artifact != nil:
+		return s.artifactKeys(ctx, builder, source.Artifact)
 	default:
 		return nil, fmt.Errorf("kubetest: invalid schedule key source")
 	}
@@ -148,22 +804,134 @@ func (s *TaskScheduler) dynamicKeys(ctx context.Context, builder *TaskBuilder, s
 	if mainResults[0].Err != nil {
 		return nil, fmt.Errorf("kubetest: failed to get dynamic key task: %w", mainResults[0].Err)
 	}
-	out := mainResults[0].Out
-	filter, err := s.sourceFilter(source.Filter)
+	keys, err := s.parseKeys(mainResults[0].Out, source.Delim, source.Filter, source.Format)
+	if err != nil {
+		return nil, err
+	}
+	LoggerFromContext(ctx).Info("found %d dynamic keys to start distributed task", len(keys))
+	return keys, nil
+}
+
+func (s *TaskScheduler) configMapKeys(ctx context.Context, builder *TaskBuilder, source *StrategyConfigMapKeySource) ([]string, error) {
+	value, err := builder.mgr.ConfigMapValue(ctx, source.Name, source.Key)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := s.parseKeys([]byte(value), source.Delim, source.Filter, source.Format)
+	if err != nil {
+		return nil, err
+	}
+	LoggerFromContext(ctx).Info("found %d keys from configmap %s to start distributed task", len(keys), source.Name)
+	return keys, nil
+}
+
+func (s *TaskScheduler) repositoryKeys(ctx context.Context, builder *TaskBuilder, source *StrategyRepositoryKeySource) ([]string, error) {
+	path, err := builder.mgr.RepositoryFilePathByName(source.Name, source.Path)
+	if err != nil {
+		return nil, err
+	}
+	out, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("kubetest: failed to read strategy keys from %s in repository %s: %w", source.Path, source.Name, err)
+	}
+	keys, err := s.parseKeys(out, source.Delim, source.Filter, source.Format)
+	if err != nil {
+		return nil, err
+	}
+	LoggerFromContext(ctx).Info("found %d keys from %s in repository %s to start distributed task", len(keys), source.Path, source.Name)
+	return keys, nil
+}
+
+func (s *TaskScheduler) artifactKeys(ctx context.Context, builder *TaskBuilder, source *StrategyArtifactKeySource) ([]string, error) {
+	path, err := builder.mgr.ArtifactPathByName(ctx, source.Name)
+	if err != nil {
+		return nil, err
+	}
+	out, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("kubetest: failed to read strategy keys from artifact %s: %w", source.Name, err)
+	}
+	keys, err := s.parseKeys(out, source.Delim, source.Filter, source.Format)
+	if err != nil {
+		return nil, err
+	}
+	LoggerFromContext(ctx).Info("found %d keys from artifact %s to start distributed task", len(keys), source.Name)
+	return keys, nil
+}
+
+// parseKeys gets keys from the output of key source by format.
+func (s *TaskScheduler) parseKeys(out []byte, delim, filter string, format StrategyDynamicKeyFormat) ([]string, error) {
+	re, err := s.sourceFilter(filter)
 	if err != nil {
 		return nil, err
 	}
+	if format == StrategyDynamicKeyFormatJSON {
+		return s.structuredKeys(out, re)
+	}
 	keys := []string{}
-	for _, key := range strings.Split(string(out), s.sourceDelim(source.Delim)) {
+	for _, key := range strings.Split(string(out), s.sourceDelim(delim)) {
 		if strings.TrimSpace(key) == "" {
 			continue
 		}
-		if filter != nil && !filter.MatchString(key) {
+		if re != nil && !re.MatchString(key) {
 			continue
 		}
 		keys = append(keys, key)
 	}
-	LoggerFromContext(ctx).Info("found %d dynamic keys to start distributed task", len(keys))
+	return keys, nil
+}
+
+// structuredKeys gets keys from the JSON array or JSON Lines of DynamicKey.
+// The env, resources and elapsed time of each key are kept to use them when scheduling.
+func (s *TaskScheduler) structuredKeys(out []byte, filter *regexp.Regexp) ([]string, error) {
+	dynamicKeys, err := parseDynamicKeys(out)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for _, key := range dynamicKeys {
+		if filter != nil && !filter.MatchString(key.Name) {
+			continue
+		}
+		if len(key.Env) > 0 {
+			s.extraEnv[key.Name] = key.Env
+		}
+		if key.Resources != nil {
+			s.keyResources[key.Name] = key.Resources
+		}
+		if key.ElapsedTimeSec > 0 {
+			s.keyElapsedTime[key.Name] = key.ElapsedTimeSec * 1000
+		}
+		keys = append(keys, key.Name)
+	}
+	return keys, nil
+}
+
+func parseDynamicKeys(out []byte) ([]DynamicKey, error) {
+	out = bytes.TrimSpace(out)
+	var keys []DynamicKey
+	if bytes.HasPrefix(out, []byte("[")) {
+		if err := json.Unmarshal(out, &keys); err != nil {
+			return nil, fmt.Errorf("kubetest: failed to decode dynamic keys: %w", err)
+		}
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(out))
+		for {
+			var key DynamicKey
+			if err := dec.Decode(&key); err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return nil, fmt.Errorf("kubetest: failed to decode dynamic keys: %w", err)
+			}
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range keys {
+		if key.Name == "" {
+			return nil, fmt.Errorf("kubetest: found dynamic key without name")
+		}
+	}
 	return keys, nil
 }
 
@@ -194,24 +962,11 @@ type SubTaskScheduler struct {
 	maxConcurrentNumPerPod int
 }
 
-func (s *SubTaskScheduler) Schedule(tasks []*SubTask) []*SubTaskGroup {
-	concurrentNum := s.getConcurrentNum(len(tasks))
-	taskNum := len(tasks)
-	groups := []*SubTaskGroup{}
-	if concurrentNum > 0 {
-		concurrent := concurrentNum
-		for i := 0; i < taskNum; i += concurrent {
-			start := i
-			end := i + concurrent
-			if end > taskNum {
-				end = taskNum
-			}
-			groups = append(groups, NewSubTaskGroup(tasks[start:end]))
-		}
-	} else {
-		groups = append(groups, NewSubTaskGroup(tasks))
-	}
-	return groups
+// Schedule creates the group that keeps maxConcurrentNumPerPod subtasks running at all times.
+func (s *SubTaskScheduler) Schedule(tasks []*SubTask) *SubTaskGroup {
+	group := NewSubTaskGroup(tasks)
+	group.maxConcurrentNum = s.getConcurrentNum(len(tasks))
+	return group
 }
 
 func (s *SubTaskScheduler) getConcurrentNum(taskNum int) int {
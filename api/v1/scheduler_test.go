@@ -2,13 +2,16 @@ package v1
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
@@ -127,20 +130,212 @@ func TestScheduler(t *testing.T) {
 			})
 		}
 	})
+	t.Run("PartitionKeysByElapsedTime", func(t *testing.T) {
+		keys := []string{"A", "B", "C", "D", "E", "F", "G"}
+		nameToElapsedTime := map[string]int64{
+			"A": 10,
+			"B": 9,
+			"C": 1,
+			"D": 1,
+			"E": 1,
+			"F": 1,
+		}
+		partitions := partitionKeysByElapsedTime(keys, 4, nameToElapsedTime)
+		if len(partitions) != 2 {
+			t.Fatalf("failed to partition keys. expected 2 but got %d", len(partitions))
+		}
+		sum := 0
+		for _, partition := range partitions {
+			if len(partition) > 4 {
+				t.Fatalf("failed to partition keys. exceeded maxContainersPerPod: %v", partition)
+			}
+			sum += len(partition)
+		}
+		if sum != len(keys) {
+			t.Fatalf("failed to partition keys: expected %d but got %d", len(keys), sum)
+		}
+		// slow keys ( A and B ) must be assigned to different pods.
+		for _, partition := range partitions {
+			var slowKeyNum int
+			for _, key := range partition {
+				if key == "A" || key == "B" {
+					slowKeyNum++
+				}
+			}
+			if slowKeyNum != 1 {
+				t.Fatalf("failed to balance keys: %v", partitions)
+			}
+		}
+	})
+	t.Run("MatrixKeys", func(t *testing.T) {
+		scheduler := NewTaskScheduler(MainStep{})
+		keys, err := scheduler.matrixKeys(WithLogger(context.Background(), NewLogger(os.Stdout, LogLevelDebug)), nil, []StrategyMatrixDimension{
+			{Env: "A", Source: StrategyKeySource{Static: []string{"1", "2"}}},
+			{Env: "B", Source: StrategyKeySource{Static: []string{"x", "y", "z"}}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{"A=1,B=x", "A=1,B=y", "A=1,B=z", "A=2,B=x", "A=2,B=y", "A=2,B=z"}
+		if fmt.Sprint(keys) != fmt.Sprint(expected) {
+			t.Fatalf("failed to expand matrix keys: expected %v but got %v", expected, keys)
+		}
+		env := scheduler.extraEnv["A=2,B=y"]
+		if len(env) != 2 || env[0].Value != "2" || env[1].Value != "y" {
+			t.Fatalf("failed to get matrix env: %v", env)
+		}
+	})
+	t.Run("MatrixKeysWithStructuredSource", func(t *testing.T) {
+		scheduler := NewTaskScheduler(MainStep{})
+		// the values of PACKAGE are parsed from the output of structured dynamic keys.
+		values, err := scheduler.structuredKeys([]byte(`
+{"name":"x","env":[{"name":"EXPECTED","value":"x"}],"elapsedTimeSec":10,"resources":{"limits":{"cpu":"2","memory":"1Gi"}}}
+{"name":"y","elapsedTimeSec":20}
+`), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scheduler.keyResources["1"] = &corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}}
+		scheduler.keyElapsedTime["1"] = 15000
+		keys, err := scheduler.matrixKeys(WithLogger(context.Background(), NewLogger(os.Stdout, LogLevelDebug)), nil, []StrategyMatrixDimension{
+			{Env: "VERSION", Source: StrategyKeySource{Static: []string{"1", "2"}}},
+			{Env: "PACKAGE", Source: StrategyKeySource{Static: values}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 4 {
+			t.Fatalf("failed to expand matrix keys: %v", keys)
+		}
+		if env := scheduler.extraEnv["VERSION=2,PACKAGE=x"]; len(env) != 3 || env[2].Value != "x" {
+			t.Fatalf("failed to get env of structured key: %v", env)
+		}
+		resources := scheduler.keyResources["VERSION=1,PACKAGE=x"]
+		if resources == nil || resources.Limits.Cpu().String() != "2" || resources.Limits.Memory().String() != "1Gi" {
+			t.Fatalf("failed to get merged resources: %v", resources)
+		}
+		if resources := scheduler.keyResources["VERSION=1,PACKAGE=y"]; resources == nil || resources.Limits.Cpu().String() != "1" {
+			t.Fatalf("failed to get resources of the dimension: %v", resources)
+		}
+		if _, exists := scheduler.keyResources["VERSION=2,PACKAGE=y"]; exists {
+			t.Fatal("the key without resources must use the resources of the template")
+		}
+		for key, expected := range map[string]int64{
+			"VERSION=1,PACKAGE=x": 15000,
+			"VERSION=1,PACKAGE=y": 20000,
+			"VERSION=2,PACKAGE=x": 10000,
+			"VERSION=2,PACKAGE=y": 20000,
+		} {
+			if elapsedTime := scheduler.keyElapsedTime[key]; elapsedTime != expected {
+				t.Fatalf("failed to get elapsed time of %s: expected %d but got %d", key, expected, elapsedTime)
+			}
+		}
+	})
+	t.Run("BatchKeys", func(t *testing.T) {
+		batch := newKeyBatch(Scheduler{KeysPerContainer: 2})
+		batchedKeys, err := batch.batchKeys([]string{"a", "b", "c"}, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(batchedKeys) != 2 || batchedKeys[0] != "a b" || batchedKeys[1] != "c" {
+			t.Fatalf("failed to batch keys: %q", batchedKeys)
+		}
+		if keys := batch.Keys(batchedKeys[0]); len(keys) != 2 || keys[1] != "b" {
+			t.Fatalf("failed to split batched key: %q", keys)
+		}
+		if _, err := batch.batchKeys([]string{"a b"}, 2); err == nil {
+			t.Fatal("expected error for the key that contains the delimiter")
+		}
+	})
+	t.Run("ShardKeys", func(t *testing.T) {
+		keys := staticSources(100)
+		reversedKeys := make([]string, 0, len(keys))
+		for i := len(keys) - 1; i >= 0; i-- {
+			reversedKeys = append(reversedKeys, keys[i])
+		}
+		keyToShard := map[string]int{}
+		for idx := 0; idx < 3; idx++ {
+			shard := &Shard{Index: idx, Total: 3}
+			keysInShard := shardKeys(keys, shard)
+			if len(keysInShard) != len(shardKeys(reversedKeys, shard)) {
+				t.Fatalf("shard %d depends on the order of keys", idx)
+			}
+			for _, key := range keysInShard {
+				if _, exists := keyToShard[key]; exists {
+					t.Fatalf("%q belongs to multiple shards", key)
+				}
+				keyToShard[key] = idx
+			}
+		}
+		if len(keyToShard) != len(keys) {
+			t.Fatalf("failed to assign all keys to shards: expected %d but got %d", len(keys), len(keyToShard))
+		}
+	})
+	t.Run("ParseDynamicKeys", func(t *testing.T) {
+		for _, out := range []string{
+			`[{"name":"a","elapsedTimeSec":3},{"name":"b","env":[{"name":"X","value":"1"}]}]`,
+			"{\"name\":\"a\",\"elapsedTimeSec\":3}\n{\"name\":\"b\",\"env\":[{\"name\":\"X\",\"value\":\"1\"}]}\n",
+		} {
+			keys, err := parseDynamicKeys([]byte(out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) != 2 || keys[0].Name != "a" || keys[0].ElapsedTimeSec != 3 || keys[1].Name != "b" || len(keys[1].Env) != 1 {
+				t.Fatalf("failed to parse dynamic keys: %+v", keys)
+			}
+		}
+		if _, err := parseDynamicKeys([]byte(`{"env":[]}`)); err == nil {
+			t.Fatal("expected error for the key without name")
+		}
+	})
+	t.Run("ExpectedElapsedTime", func(t *testing.T) {
+		scheduler := NewTaskScheduler(MainStep{})
+		scheduler.keyElapsedTime = map[string]int64{"a": 10, "b": 20}
+		nameToElapsedTime := scheduler.expectedElapsedTime(map[string]int64{"b": 5, "c": 1})
+		if nameToElapsedTime["a"] != 10 || nameToElapsedTime["b"] != 5 || nameToElapsedTime["c"] != 1 {
+			t.Fatalf("failed to merge elapsed time: %v", nameToElapsedTime)
+		}
+	})
+	t.Run("SelectImpactKeys", func(t *testing.T) {
+		impact := &StrategyImpact{
+			Rules: []StrategyImpactRule{
+				{Path: "pkg/foo/**", Keys: []string{"TestFoo*"}},
+				{Path: "pkg/bar/*.go", Keys: []string{"TestBar"}},
+				{Path: "**/*.md", Keys: []string{"TestDoc"}},
+			},
+			Fallback: []string{"TestSmoke"},
+		}
+		keys := []string{"TestSmoke", "TestFooA", "TestFooB", "TestBar", "TestDoc"}
+		for _, test := range []struct {
+			changedFiles []string
+			expected     []string
+		}{
+			{changedFiles: []string{"pkg/foo/a/b.go"}, expected: []string{"TestSmoke", "TestFooA", "TestFooB"}},
+			{changedFiles: []string{"pkg/bar/sub/c.go"}, expected: []string{"TestSmoke"}},
+			{changedFiles: []string{"pkg/bar/c.go", "README.md"}, expected: []string{"TestSmoke", "TestBar", "TestDoc"}},
+			{changedFiles: []string{"docs/a/b.md"}, expected: []string{"TestSmoke", "TestDoc"}},
+		} {
+			selectedKeys, err := selectImpactKeys(keys, test.changedFiles, impact)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(selectedKeys) != fmt.Sprint(test.expected) {
+				t.Fatalf("failed to select keys by %v: expected %v but got %v", test.changedFiles, test.expected, selectedKeys)
+			}
+		}
+	})
 	t.Run("ScheduleSubTask", func(t *testing.T) {
 		for _, test := range []struct {
 			maxConcurrentNumPerPod int
 			taskNum                int
-			expectedGroupNum       int
+			expectedConcurrentNum  int
 		}{
-			{maxConcurrentNumPerPod: 1, taskNum: 10, expectedGroupNum: 10},
-			{maxConcurrentNumPerPod: 2, taskNum: 2, expectedGroupNum: 1},
-			{maxConcurrentNumPerPod: 2, taskNum: 3, expectedGroupNum: 2},
-			{maxConcurrentNumPerPod: 2, taskNum: 9, expectedGroupNum: 5},
-			{maxConcurrentNumPerPod: 2, taskNum: 10, expectedGroupNum: 5},
-			{maxConcurrentNumPerPod: 2, taskNum: 11, expectedGroupNum: 6},
-			{maxConcurrentNumPerPod: 4, taskNum: 11, expectedGroupNum: 3},
-			{maxConcurrentNumPerPod: 12, taskNum: 12, expectedGroupNum: 1},
+			{maxConcurrentNumPerPod: 1, taskNum: 10, expectedConcurrentNum: 1},
+			{maxConcurrentNumPerPod: 2, taskNum: 2, expectedConcurrentNum: 2},
+			{maxConcurrentNumPerPod: 2, taskNum: 3, expectedConcurrentNum: 2},
+			{maxConcurrentNumPerPod: 4, taskNum: 11, expectedConcurrentNum: 4},
+			{maxConcurrentNumPerPod: 12, taskNum: 12, expectedConcurrentNum: 12},
+			{maxConcurrentNumPerPod: 16, taskNum: 12, expectedConcurrentNum: 12},
 		} {
 			name := fmt.Sprintf(
 				"maxConcurrentNumPerPod_%d_taskNum_%d",
@@ -149,18 +344,195 @@ func TestScheduler(t *testing.T) {
 			)
 			t.Run(name, func(t *testing.T) {
 				subtasks := make([]*SubTask, test.taskNum)
-				groups := NewSubTaskScheduler(test.maxConcurrentNumPerPod).Schedule(subtasks)
-				if len(groups) != test.expectedGroupNum {
-					t.Fatalf("failed to schedule subtask. expected: %d but got %d", test.expectedGroupNum, len(groups))
-				}
-				sum := 0
-				for _, group := range groups {
-					sum += len(group.tasks)
+				group := NewSubTaskScheduler(test.maxConcurrentNumPerPod).Schedule(subtasks)
+				if group.concurrentNum() != test.expectedConcurrentNum {
+					t.Fatalf("failed to schedule subtask. expected: %d but got %d", test.expectedConcurrentNum, group.concurrentNum())
 				}
-				if sum != test.taskNum {
-					t.Fatalf("failed to schedul subtask: expected %d but got %d", test.taskNum, sum)
+				if len(group.tasks) != test.taskNum {
+					t.Fatalf("failed to schedul subtask: expected %d but got %d", test.taskNum, len(group.tasks))
 				}
 			})
 		}
 	})
+	t.Run("RunSubTaskWithRollingWindow", func(t *testing.T) {
+		const (
+			maxConcurrentNum = 3
+			taskNum          = 10
+		)
+		var (
+			running    int32
+			maxRunning int32
+		)
+		// the first subtask is blocked until the others finish.
+		// if the next subtask waits for all subtasks in the window, the others never finish.
+		release := make(chan struct{})
+		finished := make(chan string, taskNum)
+		subtasks := make([]*SubTask, 0, taskNum)
+		for i := 0; i < taskNum; i++ {
+			exec := &concurrencyCheckExecutor{
+				dryRunJobExecutor: &dryRunJobExecutor{},
+				name:              fmt.Sprint(i),
+				running:           &running,
+				maxRunning:        &maxRunning,
+				finished:          finished,
+			}
+			if i == 0 {
+				exec.release = release
+			}
+			subtasks = append(subtasks, &SubTask{
+				Name:         fmt.Sprint(i),
+				exec:         exec,
+				copyArtifact: func(context.Context, *SubTask) error { return nil },
+			})
+		}
+		type runResult struct {
+			rg  *SubTaskResultGroup
+			err error
+		}
+		done := make(chan runResult)
+		go func() {
+			rg, err := NewSubTaskScheduler(maxConcurrentNum).Schedule(subtasks).Run(ctx)
+			done <- runResult{rg: rg, err: err}
+		}()
+		for i := 1; i < taskNum; i++ {
+			if name := <-finished; name == "0" {
+				t.Fatal("the blocked subtask finished before it is released")
+			}
+		}
+		close(release)
+		if name := <-finished; name != "0" {
+			t.Fatalf("expected the blocked subtask to finish but got %s", name)
+		}
+		result := <-done
+		if result.err != nil {
+			t.Fatal(result.err)
+		}
+		if len(result.rg.results) != taskNum {
+			t.Fatalf("failed to run subtasks: expected %d but got %d", taskNum, len(result.rg.results))
+		}
+		if maxRunning > maxConcurrentNum {
+			t.Fatalf("failed to limit running subtasks: expected %d but got %d", maxConcurrentNum, maxRunning)
+		}
+	})
+}
+
+func TestQuarantineKeys(t *testing.T) {
+	quarantine := &keyQuarantine{}
+	for _, pattern := range []string{"TestFlaky*", "TestKnown"} {
+		re, err := globRegexp(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		quarantine.patterns = append(quarantine.patterns, re)
+	}
+	if !quarantine.quarantinedAll([]string{"TestFlakyA", "TestKnown"}) || quarantine.quarantinedAll([]string{"TestFlakyA", "TestB"}) {
+		t.Fatal("failed to match quarantined keys")
+	}
+	var nilQuarantine *keyQuarantine
+	if nilQuarantine.quarantined("TestFlakyA") {
+		t.Fatal("expected no quarantined key")
+	}
+	rg := &TaskResultGroup{totalSubTaskNum: 3}
+	rg.add(&TaskResult{groups: []*SubTaskResultGroup{{results: []*SubTaskResult{
+		{Name: "TestFlakyA", KeyEnvName: "TEST", Status: TaskResultFailure, Err: errors.New("error")},
+		{Name: "TestKnown", KeyEnvName: "TEST", Status: TaskResultSuccess},
+		{Name: "TestB", KeyEnvName: "TEST", Status: TaskResultSuccess},
+	}}}})
+	rg.markQuarantined(quarantine)
+	if rg.Status() != ResultStatusSuccess {
+		t.Fatalf("failure of quarantined key must not change the status: %s", rg.Status())
+	}
+	if rg.FailureNum() != 0 || rg.QuarantinedFailureNum() != 1 {
+		t.Fatalf("failed to count quarantined failure: failure %d quarantined %d", rg.FailureNum(), rg.QuarantinedFailureNum())
+	}
+	if keys := rg.FailedKeys(); len(keys) != 0 {
+		t.Fatalf("quarantined keys must not be retested: %v", keys)
+	}
+	details := rg.ToReportDetails()
+	previous := &Report{Details: []*ReportDetail{
+		{Name: "TestFlakyA", SuccessStreak: 5},
+		{Name: "TestKnown", SuccessStreak: 2},
+	}}
+	updateSuccessStreak(details, previous, 3)
+	expected := map[string]struct {
+		streak           int
+		releaseCandidate bool
+	}{
+		"TestFlakyA": {streak: 0},
+		"TestKnown":  {streak: 3, releaseCandidate: true},
+		"TestB":      {streak: 0},
+	}
+	for _, detail := range details {
+		if detail.SuccessStreak != expected[detail.Name].streak || detail.ReleaseCandidate != expected[detail.Name].releaseCandidate {
+			t.Fatalf("failed to update success streak of %s: %d %v", detail.Name, detail.SuccessStreak, detail.ReleaseCandidate)
+		}
+	}
+}
+
+func TestClassifyFlakyKeys(t *testing.T) {
+	failed := func(name string) *SubTaskResult {
+		return &SubTaskResult{Name: name, KeyEnvName: "TEST", Status: TaskResultFailure, Err: errors.New("error"), Out: []byte("fail")}
+	}
+	rg := &TaskResultGroup{totalSubTaskNum: 3}
+	rg.add(&TaskResult{groups: []*SubTaskResultGroup{{results: []*SubTaskResult{
+		failed("A"),
+		failed("B"),
+		{Name: "C", KeyEnvName: "TEST", Status: TaskResultSuccess},
+	}}}})
+	rg.addRerunResult(&TaskResultGroup{results: []*TaskResult{{groups: []*SubTaskResultGroup{{results: []*SubTaskResult{failed("A"), failed("B")}}}}}})
+	rg.addRerunResult(&TaskResultGroup{results: []*TaskResult{{groups: []*SubTaskResultGroup{{results: []*SubTaskResult{
+		{Name: "A", KeyEnvName: "TEST", Status: TaskResultSuccess, Out: []byte("pass")},
+		failed("B"),
+	}}}}}})
+	if rg.ClassifiedNum(FlakyClassificationFlaky) != 1 || rg.ClassifiedNum(FlakyClassificationBroken) != 1 {
+		t.Fatalf("failed to classify failed keys: flaky %d broken %d",
+			rg.ClassifiedNum(FlakyClassificationFlaky), rg.ClassifiedNum(FlakyClassificationBroken))
+	}
+	if rg.FailureNum() != 2 || rg.SuccessNum() != 1 {
+		t.Fatalf("re-runs must not change the result: failure %d success %d", rg.FailureNum(), rg.SuccessNum())
+	}
+	for _, detail := range rg.ToReportDetails() {
+		switch detail.Name {
+		case "A":
+			if detail.Classification != FlakyClassificationFlaky || len(detail.AttemptResults) != 3 || detail.AttemptResults[2].Log != "pass" {
+				t.Fatalf("unexpected detail of flaky key: %+v", detail)
+			}
+		case "B":
+			if detail.Classification != FlakyClassificationBroken || len(detail.AttemptResults) != 3 {
+				t.Fatalf("unexpected detail of broken key: %+v", detail)
+			}
+		case "C":
+			if detail.Classification != "" || detail.AttemptResults != nil {
+				t.Fatalf("passed key must not be classified: %+v", detail)
+			}
+		}
+	}
+}
+
+// concurrencyCheckExecutor records the max number of running subtasks.
+// If release is specified, Output is blocked until it is closed. finished receives the name of the finished subtask.
+type concurrencyCheckExecutor struct {
+	*dryRunJobExecutor
+	name       string
+	running    *int32
+	maxRunning *int32
+	release    chan struct{}
+	finished   chan string
+}
+
+func (e *concurrencyCheckExecutor) Output(ctx context.Context) ([]byte, error) {
+	running := atomic.AddInt32(e.running, 1)
+	defer atomic.AddInt32(e.running, -1)
+	for {
+		max := atomic.LoadInt32(e.maxRunning)
+		if running <= max || atomic.CompareAndSwapInt32(e.maxRunning, max, running) {
+			break
+		}
+	}
+	if e.release != nil {
+		<-e.release
+	}
+	out, err := e.dryRunJobExecutor.Output(ctx)
+	e.finished <- e.name
+	return out, err
 }
@@ -3,6 +3,11 @@
 
 package v1
 
+import (
+	"fmt"
+	"time"
+)
+
 type StepType string
 
 const (
@@ -15,4 +20,22 @@ type Step interface {
 	GetName() string
 	GetType() StepType
 	GetTemplate() TestJobTemplateSpec
+	GetTimeout() string
+	GetRetryPolicy() *RetryPolicy
+	GetStrategy() *Strategy
+}
+
+// parseDuration parses the duration value like timeout of the step. If the value isn't specified, returns zero.
+func parseDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("kubetest: failed to parse duration %s: %w", value, err)
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("kubetest: duration must be greater than zero: %s", value)
+	}
+	return duration, nil
 }
@@ -5,6 +5,7 @@ package v1
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -19,10 +20,27 @@ type SubTask struct {
 	Name         string
 	TaskName     string
 	KeyEnvName   string
-	OnFinish     func(*SubTask)
+	OnFinish     func(*SubTask, *SubTaskResult)
 	exec         JobExecutor
 	isMain       bool
 	copyArtifact func(context.Context, *SubTask) error
+	// env is passed to the worker container that pulls keys from the queue.
+	env []corev1.EnvVar
+	// batch if specified, Name is the multiple keys packed into one container.
+	batch *KeyBatch
+	// timeout time limit of the command. If zero, there is no limit.
+	timeout time.Duration
+}
+
+func (t *SubTask) isWorker() bool {
+	return len(t.env) > 0
+}
+
+func (t *SubTask) output(ctx context.Context) ([]byte, error) {
+	if t.isWorker() {
+		return t.exec.ExecWithEnv(ctx, t.env)
+	}
+	return t.exec.Output(ctx)
 }
 
 func (t *SubTask) outputError(logGroup Logger, baseErr error) {
@@ -54,18 +72,31 @@ func (t *SubTask) Run(ctx context.Context) *SubTaskResult {
 	logger := LoggerFromContext(ctx)
 	logGroup := logger.Group()
 	ctx = WithLogger(ctx, logGroup)
+	var result *SubTaskResult
 	defer func() {
-		if err := t.exec.TerminationLog(ctx, terminationLog); err != nil {
-			logGroup.Warn("failed to send termination log: %s", err.Error())
+		// the worker container continues to run the next key. so doesn't send termination log.
+		if !t.isWorker() {
+			if err := t.exec.TerminationLog(ctx, terminationLog); err != nil {
+				logGroup.Warn("failed to send termination log: %s", err.Error())
+			}
 		}
 		logger.LogGroup(logGroup)
 		if t.OnFinish != nil {
-			t.OnFinish(t)
+			t.OnFinish(t, result)
 		}
 	}()
+	// execCtx has the time limit of the key. ctx is canceled by the time limit of the step, the deadline of the job or failFast.
+	execCtx := ctx
+	if t.timeout > 0 {
+		var cancel context.CancelFunc
+		execCtx, cancel = context.WithTimeout(ctx, t.timeout)
+		defer cancel()
+	}
+	stopped := t.stopOnCancel(execCtx, logGroup)
+	defer close(stopped)
 	start := time.Now()
-	out, err := t.exec.Output(ctx)
-	result := &SubTaskResult{
+	out, err := t.output(execCtx)
+	result = &SubTaskResult{
 		ElapsedTime: time.Since(start),
 		Out:         out,
 		Err:         err,
@@ -74,16 +105,46 @@ func (t *SubTask) Run(ctx context.Context) *SubTaskResult {
 		Pod:         t.exec.Pod(),
 		IsMain:      t.isMain,
 		KeyEnvName:  t.KeyEnvName,
+		Attempts:    1,
+		batch:       t.batch,
+	}
+	if usage := t.exec.ResourceUsage(); usage != nil {
+		result.PeakMemoryBytes = usage.PeakMemoryBytes
+		result.CPUTime = usage.CPUTime
 	}
 	logGroup.Debug("container: %s", t.exec.Container().Name)
 	logGroup.Log(result.Command())
 	logGroup.Log(string(out))
-	if err == nil {
+	switch {
+	case err == nil:
 		result.Status = TaskResultSuccess
-	} else {
+	case ctx.Err() == nil && errors.Is(execCtx.Err(), context.DeadlineExceeded):
+		logGroup.Warn("%s is stopped because it exceeded the key timeout", t.Name)
+		result.Status = TaskResultTimeout
+		result.Err = fmt.Errorf("kubetest: %s timed out: %w", t.Name, err)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		logGroup.Warn("%s is stopped because the step timed out", t.Name)
+		result.Status = TaskResultTimeout
+		result.Err = fmt.Errorf("kubetest: %s timed out: %w", t.Name, err)
+	case ctx.Err() != nil:
+		// the deadline of the job is reached or the task is canceled by failFast.
+		logGroup.Warn("%s is interrupted because the task is canceled", t.Name)
+		result.Status = TaskResultSkipped
+		// the error is caused by stopping the command, so the skipped key isn't regarded as failure.
+		result.Err = nil
+	default:
 		t.outputError(logGroup, err)
 		result.Status = TaskResultFailure
 	}
+	if result.Status.failed() {
+		result.setTermination(ctx, t.exec)
+		if result.Signal != "" || result.TerminationReason != "" {
+			logGroup.Warn(
+				"%s is terminated with exit code %d ( signal: %q, reason: %q, restart count: %d )",
+				t.Name, result.ExitCode, result.Signal, result.TerminationReason, result.RestartCount,
+			)
+		}
+	}
 	if t.TaskName != "" {
 		logGroup.Info("%s: elapsed time: %f sec.", t.TaskName, result.ElapsedTime.Seconds())
 	} else {
@@ -91,14 +152,49 @@ func (t *SubTask) Run(ctx context.Context) *SubTaskResult {
 	}
 	if err := t.copyArtifact(ctx, t); err != nil {
 		logGroup.Error("failed to copy artifact: %s", err.Error())
-		result.Status = TaskResultFailure
+		// the container of the timed out or skipped key may be stopped before the artifact is copied, so keeps the status.
+		if result.Status != TaskResultTimeout && result.Status != TaskResultSkipped {
+			result.Status = TaskResultFailure
+		}
 		result.ArtifactErr = err
 	}
 	return result
 }
 
+// stopOnCancel stops the running command as soon as the context is canceled.
+// Closing the returned channel finishes watching the context.
+func (t *SubTask) stopOnCancel(ctx context.Context, logGroup Logger) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			if err := t.exec.Stop(context.Background()); err != nil {
+				logGroup.Warn("failed to stop %s: %s", t.Name, err.Error())
+			}
+		case <-done:
+		}
+	}()
+	return done
+}
+
+// skippedResult returns the result of the subtask that doesn't run because the task is canceled.
+func (t *SubTask) skippedResult() *SubTaskResult {
+	return &SubTaskResult{
+		Status:     TaskResultSkipped,
+		Name:       t.Name,
+		Container:  t.exec.Container(),
+		Pod:        t.exec.Pod(),
+		IsMain:     t.isMain,
+		KeyEnvName: t.KeyEnvName,
+		batch:      t.batch,
+	}
+}
+
 type SubTaskGroup struct {
 	tasks []*SubTask
+	// maxConcurrentNum maximum number of subtasks running at the same time.
+	// If zero, runs all subtasks at the same time.
+	maxConcurrentNum int
 }
 
 func NewSubTaskGroup(tasks []*SubTask) *SubTaskGroup {
@@ -107,14 +203,30 @@ func NewSubTaskGroup(tasks []*SubTask) *SubTaskGroup {
 	}
 }
 
+func (g *SubTaskGroup) concurrentNum() int {
+	if g.maxConcurrentNum <= 0 || g.maxConcurrentNum > len(g.tasks) {
+		return len(g.tasks)
+	}
+	return g.maxConcurrentNum
+}
+
+// Run runs subtasks keeping the number of running subtasks at maxConcurrentNum.
+// As soon as one subtask finishes, the next subtask starts.
 func (g *SubTaskGroup) Run(ctx context.Context) (*SubTaskResultGroup, error) {
 	var (
 		eg errgroup.Group
 		rg SubTaskResultGroup
 	)
+	sem := make(chan struct{}, g.concurrentNum())
 	for _, task := range g.tasks {
 		task := task
+		sem <- struct{}{}
 		eg.Go(func() error {
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				rg.add(task.skippedResult())
+				return nil
+			}
 			rg.add(task.Run(ctx))
 			return nil
 		})
@@ -130,14 +242,25 @@ type TaskResultStatus int
 const (
 	TaskResultSuccess TaskResultStatus = iota
 	TaskResultFailure
+	TaskResultSkipped
+	TaskResultTimeout
 )
 
+// failed returns whether the result is failure. timeout is also regarded as failure.
+func (s TaskResultStatus) failed() bool {
+	return s == TaskResultFailure || s == TaskResultTimeout
+}
+
 func (s TaskResultStatus) ToResultStatus() ResultStatus {
 	switch s {
 	case TaskResultSuccess:
 		return ResultStatusSuccess
 	case TaskResultFailure:
 		return ResultStatusFailure
+	case TaskResultSkipped:
+		return ResultStatusSkipped
+	case TaskResultTimeout:
+		return ResultStatusTimeout
 	}
 	return ResultStatusError
 }
@@ -148,6 +271,10 @@ func (s TaskResultStatus) String() string {
 		return "success"
 	case TaskResultFailure:
 		return "failure"
+	case TaskResultSkipped:
+		return "skipped"
+	case TaskResultTimeout:
+		return "timeout"
 	}
 	return "unknown"
 }
@@ -167,6 +294,26 @@ type SubTaskResult struct {
 	Pod         *corev1.Pod
 	KeyEnvName  string
 	IsMain      bool
+	Attempts    int
+	// Retries number of times the pod was recreated by retryPolicy before the result.
+	Retries int
+	// ExitCode exit code of the failed command. If the command is killed by the signal, 128 + the signal number.
+	ExitCode int
+	// Signal name of the signal that killed the command ( e.g. SIGKILL ).
+	Signal string
+	// TerminationReason reason of the container termination ( e.g. OOMKilled, Error, DeadlineExceeded ).
+	TerminationReason string
+	// RestartCount number of times the container has been restarted.
+	RestartCount int32
+	// PeakMemoryBytes peak memory usage of the command. If zero, it isn't measured.
+	PeakMemoryBytes int64
+	// CPUTime CPU time consumed by the command. If zero, it isn't measured.
+	CPUTime time.Duration
+	// Quarantined the key is quarantined by strategy.quarantine. Its failure doesn't change the status.
+	Quarantined bool
+	// RepeatedElapsedTimes elapsed time of each run of the key run repeatedly by strategy.repeat.
+	RepeatedElapsedTimes []time.Duration
+	batch                *KeyBatch
 }
 
 func (r *SubTaskResult) Error() error {
@@ -183,6 +330,20 @@ func (r *SubTaskResult) Error() error {
 	return nil
 }
 
+// lost reports whether the key was interrupted because the pod was lost.
+// The key whose command exited by itself is regarded as finished even if it failed,
+// unless the pod is evicted or its node is lost because the exec stream of such pod may end as if the command exited.
+func (r *SubTaskResult) lost() bool {
+	switch r.Status {
+	case TaskResultSuccess, TaskResultTimeout:
+		return false
+	}
+	if isLostPod(r.Pod) {
+		return true
+	}
+	return r.ArtifactErr == nil && !isExitError(r.Err)
+}
+
 func (r *SubTaskResult) Command() string {
 	cmd := strings.Join(append(r.Container.Command, r.Container.Args...), " ")
 	envName := r.KeyEnvName
@@ -192,6 +353,37 @@ func (r *SubTaskResult) Command() string {
 	return cmd
 }
 
+// splitByKey splits the result of the container that runs multiple keys into the result of each key.
+// The elapsed time and CPU time of each key are regarded as the average of them, and the peak memory is the same as the container.
+func (r *SubTaskResult) splitByKey() []*SubTaskResult {
+	if r.batch == nil {
+		return []*SubTaskResult{r}
+	}
+	keys := r.batch.Keys(r.Name)
+	keyToStatus := r.batch.parseResults(r.Out)
+	results := make([]*SubTaskResult, 0, len(keys))
+	for _, key := range keys {
+		result := *r
+		result.Name = key
+		result.ElapsedTime = r.ElapsedTime / time.Duration(len(keys))
+		result.CPUTime = r.CPUTime / time.Duration(len(keys))
+		result.batch = nil
+		if status, exists := keyToStatus[key]; exists && r.ArtifactErr == nil {
+			result.Status = status
+			switch status {
+			case TaskResultSuccess:
+				result.Err = nil
+			case TaskResultFailure:
+				if result.Err == nil {
+					result.Err = fmt.Errorf("kubetest: %s is reported as failure", key)
+				}
+			}
+		}
+		results = append(results, &result)
+	}
+	return results
+}
+
 type SubTaskResultGroup struct {
 	results []*SubTaskResult
 	mu      sync.Mutex
@@ -199,6 +391,6 @@ type SubTaskResultGroup struct {
 
 func (g *SubTaskResultGroup) add(result *SubTaskResult) {
 	g.mu.Lock()
-	g.results = append(g.results, result)
+	g.results = append(g.results, result.splitByKey()...)
 	g.mu.Unlock()
 }
@@ -0,0 +1,181 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goccy/kubejob"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestKeyTimeout(t *testing.T) {
+	ctx := WithLogger(context.Background(), NewLogger(os.Stdout, LogLevelDebug))
+	t.Run("kubernetes executor returns on timeout", func(t *testing.T) {
+		exec := &kubernetesJobExecutor{exec: &kubejob.JobExecutor{}}
+		release := make(chan struct{})
+		defer close(release)
+		timeoutCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		_, err := exec.execWithContext(timeoutCtx, func() ([]byte, error) {
+			// the command in the container isn't interrupted by the context.
+			<-release
+			return nil, nil
+		})
+		var failedJob *kubejob.FailedJob
+		if !errors.As(err, &failedJob) || !errors.Is(failedJob.Reason, context.DeadlineExceeded) {
+			t.Fatalf("expected timeout error but got %v", err)
+		}
+	})
+	t.Run("stopped container keeps timeout status", func(t *testing.T) {
+		exec := newStoppableExecutor("test")
+		result := (&SubTask{
+			Name:         "A",
+			exec:         exec,
+			isMain:       true,
+			timeout:      100 * time.Millisecond,
+			copyArtifact: exec.copyArtifact,
+		}).Run(ctx)
+		if result.Status != TaskResultTimeout {
+			t.Fatalf("failed to get status: expected timeout but got %s", result.Status)
+		}
+		if result.ArtifactErr == nil {
+			t.Fatal("expected artifact error from the stopped container")
+		}
+	})
+	t.Run("worker stops after timeout", func(t *testing.T) {
+		exec := newStoppableExecutor("test0-0")
+		queue := NewKeyQueue([]string{"A", "B", "C"})
+		task := &Task{
+			strategyKey: &StrategyKey{
+				Env:        "TEST",
+				Queue:      queue,
+				WorkerNum:  1,
+				KeyTimeout: 100 * time.Millisecond,
+			},
+			copyArtifact: exec.copyArtifact,
+		}
+		rg := task.runWorkers(ctx, []JobExecutor{exec})
+		if len(rg.results) != 1 || rg.results[0].Status != TaskResultTimeout {
+			t.Fatalf("worker must stop after the first key timed out: %d results", len(rg.results))
+		}
+		if key, _ := queue.Pop(); key != "B" {
+			t.Fatalf("the remaining keys must stay in the queue: got %q", key)
+		}
+	})
+	t.Run("ValidateStrategy", func(t *testing.T) {
+		strategy := &Strategy{
+			Key:        StrategyKeySpec{Env: "TEST", Source: StrategyKeySource{Static: []string{"A"}}},
+			Scheduler:  Scheduler{MaxContainersPerPod: 1, MaxConcurrentNumPerPod: 1, Mode: SchedulerModeQueue},
+			KeyTimeout: "1m",
+		}
+		if err := NewValidator().ValidateStrategy(strategy); err == nil {
+			t.Fatal("expected error for keyTimeout with queue mode")
+		}
+	})
+}
+
+func TestQueueWorker(t *testing.T) {
+	ctx := WithLogger(context.Background(), NewLogger(os.Stdout, LogLevelDebug))
+	execs := []*workerTestExecutor{
+		{dryRunJobExecutor: &dryRunJobExecutor{container: corev1.Container{Name: "test0-0"}}},
+		{dryRunJobExecutor: &dryRunJobExecutor{container: corev1.Container{Name: "test0-1"}}},
+	}
+	task := &Task{
+		strategyKey: &StrategyKey{
+			Env:       "TEST",
+			Queue:     NewKeyQueue([]string{"A", "B", "C"}),
+			WorkerNum: 2,
+		},
+		copyArtifact: func(context.Context, *SubTask) error { return nil },
+	}
+	rg := task.runWorkers(ctx, []JobExecutor{execs[0], execs[1]})
+	if len(rg.results) != 3 {
+		t.Fatalf("failed to run all keys: %d results", len(rg.results))
+	}
+	for _, exec := range execs {
+		if stoppedNum := atomic.LoadInt32(&exec.stoppedNum); stoppedNum != 1 {
+			t.Fatalf("worker %s must be stopped once after the queue is empty: %d", exec.container.Name, stoppedNum)
+		}
+	}
+}
+
+func TestCanceledSubTask(t *testing.T) {
+	ctx, cancel := context.WithCancel(WithLogger(context.Background(), NewLogger(os.Stdout, LogLevelDebug)))
+	exec := newStoppableExecutor("test")
+	// the deadline is reached while the key is running.
+	time.AfterFunc(100*time.Millisecond, cancel)
+	result := (&SubTask{
+		Name:         "A",
+		exec:         exec,
+		isMain:       true,
+		copyArtifact: exec.copyArtifact,
+	}).Run(ctx)
+	if result.Status != TaskResultSkipped {
+		t.Fatalf("failed to get status: expected skipped but got %s", result.Status)
+	}
+	if result.Err != nil {
+		t.Fatalf("skipped key must not have error: %v", result.Err)
+	}
+	var rg TaskResultGroup
+	rg.add(&TaskResult{groups: []*SubTaskResultGroup{{results: []*SubTaskResult{result}}}})
+	if status := rg.Status(); status != ResultStatusSuccess {
+		t.Fatalf("skipped key must not be regarded as failure: %s", status)
+	}
+	if keys := rg.FailedKeys(); len(keys) != 0 {
+		t.Fatalf("skipped key must not be failed key: %v", keys)
+	}
+}
+
+// stoppableExecutor behaves like the executor of kubernetes mode.
+// The command ignores the context, and Stop terminates the whole container so that it can't run commands or copy files after that.
+type stoppableExecutor struct {
+	*dryRunJobExecutor
+	stopped chan struct{}
+	once    sync.Once
+}
+
+func newStoppableExecutor(name string) *stoppableExecutor {
+	return &stoppableExecutor{
+		dryRunJobExecutor: &dryRunJobExecutor{container: corev1.Container{Name: name}},
+		stopped:           make(chan struct{}),
+	}
+}
+
+func (e *stoppableExecutor) Output(_ context.Context) ([]byte, error) {
+	<-e.stopped
+	return nil, errors.New("container is stopped")
+}
+
+func (e *stoppableExecutor) ExecWithEnv(ctx context.Context, _ []corev1.EnvVar) ([]byte, error) {
+	return e.Output(ctx)
+}
+
+func (e *stoppableExecutor) Stop(_ context.Context) error {
+	e.once.Do(func() { close(e.stopped) })
+	return nil
+}
+
+func (e *stoppableExecutor) copyArtifact(_ context.Context, _ *SubTask) error {
+	select {
+	case <-e.stopped:
+		return errors.New("container is stopped")
+	default:
+		return nil
+	}
+}
+
+// workerTestExecutor counts the number of times the worker container is stopped.
+type workerTestExecutor struct {
+	*dryRunJobExecutor
+	stoppedNum int32
+}
+
+func (e *workerTestExecutor) Stop(_ context.Context) error {
+	atomic.AddInt32(&e.stoppedNum, 1)
+	return nil
+}
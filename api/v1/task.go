@@ -6,6 +6,7 @@ package v1
 import (
 	"context"
 	"errors"
+	"sort"
 	"sync"
 	"time"
 
@@ -17,18 +18,31 @@ import (
 
 type Task struct {
 	Name              string
-	OnFinishSubTask   func(*SubTask)
+	OnFinishSubTask   func(*SubTask, *SubTaskResult)
 	job               Job
 	copyArtifact      func(context.Context, *SubTask) error
 	strategyKey       *StrategyKey
 	mainContainerName string
-	createJob         func(context.Context) (Job, error)
+	// createJob creates the job that runs the specified keys.
+	createJob func(context.Context, *StrategyKey) (Job, error)
+	// timeout time limit of the task. If zero, there is no limit.
+	timeout     time.Duration
+	retryPolicy *taskRetryPolicy
 }
 
 func (t *Task) SubTaskNum() int {
+	if t.strategyKey != nil && t.strategyKey.Queue != nil {
+		// keys are pulled from the queue shared by multiple tasks, so TaskGroup counts them.
+		return 0
+	}
 	subTaskNum := 0
 	for _, c := range t.job.Spec().Template.Spec.Containers {
-		if t.isMainContainer(c) {
+		if !t.isMainContainer(c) {
+			continue
+		}
+		if t.strategyKey != nil {
+			subTaskNum += len(t.strategyKey.Batch.Keys(t.getKeyName(c)))
+		} else {
 			subTaskNum++
 		}
 	}
@@ -36,28 +50,18 @@ func (t *Task) SubTaskNum() int {
 }
 
 func (t *Task) Run(ctx context.Context) (*TaskResult, error) {
-	return t.runWithRetry(ctx)
-}
-
-func (t *Task) retryableError(err error) bool {
-	if err == nil {
-		return false
-	}
-	switch err.(type) {
-	case *kubejob.PreInitError:
-		return true
-	case *kubejob.PendingPhaseTimeoutError:
-		return true
+	if t.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.timeout)
+		defer cancel()
 	}
-	return false
+	return t.runWithRetry(ctx)
 }
 
 func (t *Task) runWithRetry(ctx context.Context) (*TaskResult, error) {
-	const taskRetryCount = 2
-
 	policy := backoff.NewExponential(
-		backoff.WithInterval(1*time.Second),
-		backoff.WithMaxRetries(taskRetryCount),
+		backoff.WithInterval(t.retryPolicy.backoff),
+		backoff.WithMaxRetries(t.retryPolicy.count),
 	)
 	b, cancel := policy.Start(context.Background())
 	defer cancel()
@@ -66,36 +70,124 @@ func (t *Task) runWithRetry(ctx context.Context) (*TaskResult, error) {
 		result     *TaskResult
 		err        error
 		retryCount int
+		// finished results of the keys that finished before the pod was lost.
+		finished SubTaskResultGroup
 	)
 	for backoff.Continue(b) {
 		result, err = t.run(ctx)
-		if err != nil {
-			if t.retryableError(err) {
-				LoggerFromContext(ctx).Warn(
-					"failed to run task because %s. retry %d/%d",
-					err, retryCount, taskRetryCount,
-				)
-				// Recreate the job because the internal state of the job has already changed.
-				job, err := t.createJob(ctx)
-				if err != nil {
-					return nil, err
+		if retryCount >= t.retryPolicy.count || ctx.Err() != nil {
+			break
+		}
+		runErr := err
+		if runErr == nil {
+			runErr = result.Err
+		}
+		if runErr == nil && result != nil {
+			runErr = lostPodError(result)
+		}
+		condition, exists := retryConditionByError(runErr)
+		if !exists || !t.retryPolicy.retryable(condition) {
+			break
+		}
+		strategyKey := t.strategyKey
+		if strategyKey != nil && result != nil {
+			results, remainingKeys := t.splitFinishedKeys(result)
+			for _, r := range results {
+				r.Retries = retryCount
+			}
+			finished.results = append(finished.results, results...)
+			if strategyKey.Queue != nil {
+				// the unfinished keys are pulled again by the recreated pod or the other pods.
+				strategyKey.Queue.Push(remainingKeys...)
+			} else {
+				if len(remainingKeys) == 0 {
+					LoggerFromContext(ctx).Warn("%s ( %s ) but all keys have already finished", runErr, condition)
+					result = &TaskResult{}
+					break
 				}
-				t.job = job
-				retryCount++
-				continue
+				strategyKey = strategyKey.withKeys(remainingKeys)
 			}
 		}
-		break
+		retryCount++
+		LoggerFromContext(ctx).Warn(
+			"failed to run task because %s ( %s ). retry %d/%d",
+			runErr, condition, retryCount, t.retryPolicy.count,
+		)
+		// Recreate the job because the internal state of the job has already changed.
+		job, err := t.createJob(ctx, strategyKey)
+		if err != nil {
+			return nil, err
+		}
+		t.job = job
+		t.strategyKey = strategyKey
+	}
+	if result != nil {
+		result.setRetryNum(retryCount)
+		if len(finished.results) > 0 {
+			result.add(&finished)
+		}
 	}
 	return result, err
 }
 
+// splitFinishedKeys splits the result of the lost pod into the results of the keys that have already finished and the keys that need to run again.
+// If keys are batched, the unfinished keys of the same container are joined again.
+func (t *Task) splitFinishedKeys(result *TaskResult) ([]*SubTaskResult, []string) {
+	var (
+		finished      []*SubTaskResult
+		finishedKeys  = map[string]struct{}{}
+		containerKeys = map[string][]string{}
+		containers    []string
+	)
+	for _, r := range result.MainTaskResults() {
+		if !r.lost() {
+			finished = append(finished, r)
+			finishedKeys[r.Name] = struct{}{}
+			continue
+		}
+		if _, exists := containerKeys[r.Container.Name]; !exists {
+			containers = append(containers, r.Container.Name)
+		}
+		containerKeys[r.Container.Name] = append(containerKeys[r.Container.Name], r.Name)
+	}
+	batch := t.strategyKey.Batch
+	var remainingKeys []string
+	if t.strategyKey.Queue != nil {
+		// the worker container of queue mode is lost while running the last key it pulled.
+		for _, container := range containers {
+			remainingKeys = append(remainingKeys, batch.join(containerKeys[container]))
+		}
+		return finished, remainingKeys
+	}
+	// the keys that have no result are also unfinished because the pod was lost before running them.
+	for _, batchedKey := range t.strategyKey.Keys {
+		var keys []string
+		for _, key := range batch.Keys(batchedKey) {
+			if _, exists := finishedKeys[key]; !exists {
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) > 0 {
+			remainingKeys = append(remainingKeys, batch.join(keys))
+		}
+	}
+	return finished, remainingKeys
+}
+
 func (t *Task) run(ctx context.Context) (*TaskResult, error) {
-	var result TaskResult
+	var (
+		result TaskResult
+		execs  []JobExecutor
+	)
 	if err := t.job.RunWithExecutionHandler(ctx, func(executors []JobExecutor) error {
+		execs = executors
 		for _, sidecar := range t.sideCarExecutors(executors) {
 			sidecar.ExecAsync(ctx)
 		}
+		if t.strategyKey != nil && t.strategyKey.Queue != nil {
+			result.add(t.runWorkers(ctx, t.mainExecutors(executors)))
+			return nil
+		}
 		subTasks := t.getSubTasks(t.mainExecutors(executors))
 		if t.strategyKey == nil {
 			group, err := NewSubTaskGroup(subTasks).Run(ctx)
@@ -105,32 +197,126 @@ func (t *Task) run(ctx context.Context) (*TaskResult, error) {
 			result.add(group)
 			return nil
 		}
-		subTaskGroups := t.strategyKey.SubTaskScheduler.Schedule(subTasks)
-		for _, subTaskGroup := range subTaskGroups {
-			rg, err := subTaskGroup.Run(ctx)
-			if err != nil {
-				return err
-			}
-			result.add(rg)
+		rg, err := t.strategyKey.SubTaskScheduler.Schedule(subTasks).Run(ctx)
+		if err != nil {
+			return err
 		}
+		result.add(rg)
 		return nil
 	}); err != nil {
 		var failedJob *kubejob.FailedJob
 		if !errors.As(err, &failedJob) {
 			return nil, err
 		}
-		result.Err = err
+		result.Err = withLatestPod(ctx, failedJob, execs)
 	}
 	return &result, nil
 }
 
+// runWorkers runs each executor as a worker that pulls the next key from the queue until the queue is empty.
+func (t *Task) runWorkers(ctx context.Context, execs []JobExecutor) *SubTaskResultGroup {
+	var (
+		eg errgroup.Group
+		rg SubTaskResultGroup
+	)
+	queue := t.strategyKey.Queue
+	for _, exec := range execs {
+		exec := exec
+		eg.Go(func() error {
+			// the worker container waits for the next key without its own command, so it's stopped as soon as the worker finishes.
+			defer stopWorker(ctx, exec)
+			for ctx.Err() == nil {
+				key, exists := queue.Pop()
+				if !exists {
+					return nil
+				}
+				result := t.workerSubTask(exec, key).Run(ctx)
+				rg.add(result)
+				if result.lost() {
+					// the pod was lost. stop pulling keys so that they remain in the queue for the other pods.
+					return nil
+				}
+				if result.Status == TaskResultTimeout {
+					// the worker container is stopped to interrupt the command, so it can't run the next key.
+					return nil
+				}
+			}
+			return nil
+		})
+	}
+	_ = eg.Wait()
+	return &rg
+}
+
+func stopWorker(ctx context.Context, exec JobExecutor) {
+	if err := exec.Stop(context.Background()); err != nil {
+		LoggerFromContext(ctx).Warn("failed to stop worker %s: %s", exec.Container().Name, err.Error())
+	}
+}
+
+// skippedResult returns the result of the task that doesn't start because the task group is canceled.
+func (t *Task) skippedResult() *TaskResult {
+	var (
+		result TaskResult
+		rg     SubTaskResultGroup
+	)
+	for _, container := range t.job.Spec().Template.Spec.Containers {
+		if !t.isMainContainer(container) {
+			continue
+		}
+		// the worker container of queue mode has no key. the keys remaining in the queue are recorded by TaskGroup.
+		if t.strategyKey != nil && t.strategyKey.Queue != nil {
+			continue
+		}
+		var (
+			envName string
+			batch   *KeyBatch
+		)
+		if t.strategyKey != nil {
+			envName = t.strategyKey.Env
+			batch = t.strategyKey.Batch
+		}
+		rg.add(&SubTaskResult{
+			Status:     TaskResultSkipped,
+			Name:       t.getKeyName(container),
+			Container:  container,
+			IsMain:     true,
+			KeyEnvName: envName,
+			batch:      batch,
+		})
+	}
+	result.add(&rg)
+	return &result
+}
+
+func (t *Task) workerSubTask(exec JobExecutor, key string) *SubTask {
+	return &SubTask{
+		Name:         key,
+		TaskName:     t.Name,
+		KeyEnvName:   t.strategyKey.Env,
+		OnFinish:     t.OnFinishSubTask,
+		exec:         exec,
+		copyArtifact: t.copyArtifact,
+		isMain:       true,
+		env:          t.strategyKey.EnvVars(key),
+		batch:        t.strategyKey.Batch,
+		timeout:      t.strategyKey.KeyTimeout,
+	}
+}
+
 func (t *Task) getSubTasks(execs []JobExecutor) []*SubTask {
 	tasks := make([]*SubTask, 0, len(execs))
 	for _, exec := range execs {
 		container := exec.Container()
-		var envName string
+		var (
+			envName string
+			batch   *KeyBatch
+			timeout time.Duration
+		)
 		if t.strategyKey != nil {
 			envName = t.strategyKey.Env
+			batch = t.strategyKey.Batch
+			timeout = t.strategyKey.KeyTimeout
 		}
 		tasks = append(tasks, &SubTask{
 			Name:         t.getKeyName(container),
@@ -140,6 +326,8 @@ func (t *Task) getSubTasks(execs []JobExecutor) []*SubTask {
 			exec:         exec,
 			copyArtifact: t.copyArtifact,
 			isMain:       t.isMainExecutor(exec),
+			batch:        batch,
+			timeout:      timeout,
 		})
 	}
 	return tasks
@@ -201,6 +389,20 @@ func (t *Task) hasKeyEnv(container corev1.Container) bool {
 
 type TaskGroup struct {
 	tasks []*Task
+	// cancel cancels the context shared by all tasks. it is available while running.
+	cancel context.CancelFunc
+	// timeout time limit of all tasks. If zero, there is no limit.
+	timeout time.Duration
+	// maxConcurrentNum maximum number of tasks ( pods ) of the step running at the same time.
+	// If zero, runs all tasks at the same time.
+	maxConcurrentNum int
+	runningNum       int
+	finishedNum      int
+	// quarantine patterns of quarantined keys. The results of them are marked as quarantined.
+	quarantine *keyQuarantine
+	// repeat number of runs of each key. The results of the same key are merged into one result.
+	repeat int
+	mu     sync.Mutex
 }
 
 func NewTaskGroup(tasks []*Task) *TaskGroup {
@@ -215,13 +417,41 @@ func (g *TaskGroup) Run(ctx context.Context) (*TaskResultGroup, error) {
 		rg TaskResultGroup
 	)
 	totalSubTaskNum := 0
+	queues := map[*KeyQueue]struct{}{}
 	for _, task := range g.tasks {
 		totalSubTaskNum += task.SubTaskNum()
+		if task.strategyKey != nil && task.strategyKey.Queue != nil {
+			queues[task.strategyKey.Queue] = struct{}{}
+		}
+	}
+	for queue := range queues {
+		totalSubTaskNum += queue.KeyNum()
 	}
 	rg.totalSubTaskNum = totalSubTaskNum
+	if g.timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, g.timeout)
+		defer cancelTimeout()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	g.mu.Lock()
+	g.cancel = cancel
+	g.mu.Unlock()
+	sem := make(chan struct{}, g.concurrentNum())
 	for _, task := range g.tasks {
 		task := task
+		sem <- struct{}{}
+		g.start()
 		eg.Go(func() error {
+			defer func() {
+				g.finish()
+				<-sem
+			}()
+			if ctx.Err() != nil {
+				rg.add(task.skippedResult())
+				return nil
+			}
 			result, err := task.Run(ctx)
 			if err != nil {
 				return err
@@ -233,12 +463,91 @@ func (g *TaskGroup) Run(ctx context.Context) (*TaskResultGroup, error) {
 	if err := eg.Wait(); err != nil {
 		return nil, err
 	}
+	for queue := range queues {
+		// the keys remaining in the queue are not run because the tasks are canceled.
+		rg.add(g.skippedQueueResult(queue))
+	}
+	rg.markQuarantined(g.quarantine)
+	rg.mergeRepeatedResults(g.repeat)
 	return &rg, nil
 }
 
+// Cancel cancels all running tasks. The keys that haven't finished are recorded as skipped.
+func (g *TaskGroup) Cancel() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.cancel != nil {
+		g.cancel()
+	}
+}
+
+func (g *TaskGroup) skippedQueueResult(queue *KeyQueue) *TaskResult {
+	var rg SubTaskResultGroup
+	for _, task := range g.tasks {
+		if task.strategyKey == nil || task.strategyKey.Queue != queue {
+			continue
+		}
+		for {
+			key, exists := queue.Pop()
+			if !exists {
+				break
+			}
+			rg.add(&SubTaskResult{
+				Status:     TaskResultSkipped,
+				Name:       key,
+				IsMain:     true,
+				KeyEnvName: task.strategyKey.Env,
+				batch:      task.strategyKey.Batch,
+			})
+		}
+		break
+	}
+	var result TaskResult
+	result.add(&rg)
+	return &result
+}
+
+func (g *TaskGroup) concurrentNum() int {
+	if g.maxConcurrentNum <= 0 || g.maxConcurrentNum > len(g.tasks) {
+		return len(g.tasks)
+	}
+	return g.maxConcurrentNum
+}
+
+func (g *TaskGroup) start() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.runningNum++
+}
+
+func (g *TaskGroup) finish() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.runningNum--
+	g.finishedNum++
+}
+
+// PodNum returns the number of running tasks and the number of tasks waiting to start.
+func (g *TaskGroup) PodNum() (int, int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.runningNum, len(g.tasks) - g.runningNum - g.finishedNum
+}
+
 type TaskResult struct {
-	Err    error
-	groups []*SubTaskResultGroup
+	Err error
+	// RetryNum number of times the pod was recreated by retryPolicy.
+	RetryNum int
+	groups   []*SubTaskResultGroup
+}
+
+func (r *TaskResult) setRetryNum(retryNum int) {
+	r.RetryNum = retryNum
+	for _, group := range r.groups {
+		for _, result := range group.results {
+			result.Retries = retryNum
+		}
+	}
 }
 
 func (r *TaskResult) MainTaskResults() []*SubTaskResult {
@@ -260,7 +569,10 @@ func (r *TaskResult) add(group *SubTaskResultGroup) {
 type TaskResultGroup struct {
 	totalSubTaskNum int
 	results         []*TaskResult
-	mu              sync.Mutex
+	// reruns results of re-runs by flakyDetection for each key name.
+	// They are kept in addition to the first result and aren't counted as the result of the key.
+	reruns map[string][]*SubTaskResult
+	mu     sync.Mutex
 }
 
 func (g *TaskResultGroup) TotalNum() int {
@@ -286,7 +598,7 @@ func (g *TaskResultGroup) FailureNum() int {
 	for _, result := range g.results {
 		for _, group := range result.groups {
 			for _, subTaskResult := range group.results {
-				if subTaskResult.Status == TaskResultFailure {
+				if subTaskResult.Status == TaskResultFailure && !subTaskResult.Quarantined {
 					failureNum++
 				}
 			}
@@ -295,10 +607,81 @@ func (g *TaskResultGroup) FailureNum() int {
 	return failureNum
 }
 
+func (g *TaskResultGroup) SkippedNum() int {
+	skippedNum := 0
+	for _, result := range g.results {
+		for _, group := range result.groups {
+			for _, subTaskResult := range group.results {
+				if subTaskResult.Status == TaskResultSkipped {
+					skippedNum++
+				}
+			}
+		}
+	}
+	return skippedNum
+}
+
+func (g *TaskResultGroup) TimeoutNum() int {
+	timeoutNum := 0
+	for _, result := range g.results {
+		for _, group := range result.groups {
+			for _, subTaskResult := range group.results {
+				if subTaskResult.Status == TaskResultTimeout && !subTaskResult.Quarantined {
+					timeoutNum++
+				}
+			}
+		}
+	}
+	return timeoutNum
+}
+
+// QuarantinedFailureNum returns the number of quarantined keys that failed or timed out.
+func (g *TaskResultGroup) QuarantinedFailureNum() int {
+	failureNum := 0
+	for _, result := range g.results {
+		for _, group := range result.groups {
+			for _, subTaskResult := range group.results {
+				if subTaskResult.Status.failed() && subTaskResult.Quarantined {
+					failureNum++
+				}
+			}
+		}
+	}
+	return failureNum
+}
+
+// markQuarantined marks the results of quarantined keys.
+func (g *TaskResultGroup) markQuarantined(quarantine *keyQuarantine) {
+	if quarantine == nil {
+		return
+	}
+	for _, result := range g.results {
+		for _, group := range result.groups {
+			for _, subTaskResult := range group.results {
+				if subTaskResult.KeyEnvName != "" && quarantine.quarantined(subTaskResult.Name) {
+					subTaskResult.Quarantined = true
+				}
+			}
+		}
+	}
+}
+
+// RetryNum returns the total number of times the pods of all tasks were recreated by retryPolicy.
+func (g *TaskResultGroup) RetryNum() int {
+	retryNum := 0
+	for _, result := range g.results {
+		retryNum += result.RetryNum
+	}
+	return retryNum
+}
+
 func (g *TaskResultGroup) Status() ResultStatus {
 	for _, result := range g.results {
 		for _, group := range result.groups {
 			for _, subTaskResult := range group.results {
+				if subTaskResult.Quarantined || subTaskResult.Status == TaskResultSkipped {
+					continue
+				}
 				if err := subTaskResult.Error(); err != nil {
 					return ResultStatusFailure
 				}
@@ -313,17 +696,169 @@ func (g *TaskResultGroup) ToReportDetails() []*ReportDetail {
 	for _, result := range g.results {
 		for _, group := range result.groups {
 			for _, subTaskResult := range group.results {
-				details = append(details, &ReportDetail{
-					Status:         subTaskResult.Status.ToResultStatus(),
-					Name:           subTaskResult.Name,
-					ElapsedTimeSec: int64(subTaskResult.ElapsedTime.Seconds()),
-				})
+				detail := &ReportDetail{
+					Status:            subTaskResult.Status.ToResultStatus(),
+					Name:              subTaskResult.Name,
+					ElapsedTimeSec:    int64(subTaskResult.ElapsedTime.Seconds()),
+					ElapsedTimeMsec:   subTaskResult.ElapsedTime.Milliseconds(),
+					ElapsedTimeStats:  newElapsedTimeStats(subTaskResult.RepeatedElapsedTimes),
+					Attempts:          subTaskResult.Attempts,
+					Retries:           subTaskResult.Retries,
+					ExitCode:          subTaskResult.ExitCode,
+					Signal:            subTaskResult.Signal,
+					TerminationReason: subTaskResult.TerminationReason,
+					RestartCount:      subTaskResult.RestartCount,
+					PeakMemoryBytes:   subTaskResult.PeakMemoryBytes,
+					CPUTimeMsec:       subTaskResult.CPUTime.Milliseconds(),
+					Quarantined:       subTaskResult.Quarantined,
+				}
+				if classification := g.classification(subTaskResult.Name); classification != "" && subTaskResult.Status.failed() {
+					detail.Classification = classification
+					detail.AttemptResults = g.attemptResults(subTaskResult)
+				}
+				details = append(details, detail)
 			}
 		}
 	}
 	return details
 }
 
+// HeaviestResults returns at most n subtask results in descending order of the usage.
+// The results whose usage isn't measured are excluded.
+func (g *TaskResultGroup) HeaviestResults(n int, usage func(*SubTaskResult) int64) []*SubTaskResult {
+	results := []*SubTaskResult{}
+	for _, result := range g.results {
+		for _, group := range result.groups {
+			for _, subTaskResult := range group.results {
+				if usage(subTaskResult) > 0 {
+					results = append(results, subTaskResult)
+				}
+			}
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return usage(results[i]) > usage(results[j])
+	})
+	if len(results) > n {
+		return results[:n]
+	}
+	return results
+}
+
+// FailedKeys returns strategy keys of failed subtasks. Quarantined keys are excluded.
+func (g *TaskResultGroup) FailedKeys() []string {
+	keys := []string{}
+	for _, result := range g.results {
+		for _, group := range result.groups {
+			for _, subTaskResult := range group.results {
+				if subTaskResult.KeyEnvName == "" || subTaskResult.Quarantined {
+					continue
+				}
+				if subTaskResult.Status.failed() {
+					keys = append(keys, subTaskResult.Name)
+				}
+			}
+		}
+	}
+	return keys
+}
+
+// mergeRetestResult replaces failed subtask results by the results of retest.
+// Only the final outcome of each key is kept, and the number of attempts is accumulated.
+func (g *TaskResultGroup) mergeRetestResult(retestResult *TaskResultGroup) {
+	retestedKeys := map[string]struct{}{}
+	for _, result := range retestResult.results {
+		for _, group := range result.groups {
+			for _, subTaskResult := range group.results {
+				retestedKeys[subTaskResult.Name] = struct{}{}
+			}
+		}
+	}
+	keyToAttempts := map[string]int{}
+	keyToRetries := map[string]int{}
+	for _, result := range g.results {
+		for _, group := range result.groups {
+			filtered := make([]*SubTaskResult, 0, len(group.results))
+			for _, subTaskResult := range group.results {
+				if _, exists := retestedKeys[subTaskResult.Name]; exists && subTaskResult.Status.failed() {
+					keyToAttempts[subTaskResult.Name] = subTaskResult.Attempts
+					keyToRetries[subTaskResult.Name] = subTaskResult.Retries
+					continue
+				}
+				filtered = append(filtered, subTaskResult)
+			}
+			group.results = filtered
+		}
+	}
+	for _, result := range retestResult.results {
+		for _, group := range result.groups {
+			for _, subTaskResult := range group.results {
+				subTaskResult.Attempts += keyToAttempts[subTaskResult.Name]
+				subTaskResult.Retries += keyToRetries[subTaskResult.Name]
+			}
+		}
+		g.add(result)
+	}
+}
+
+// addRerunResult keeps the results of re-runs by flakyDetection.
+func (g *TaskResultGroup) addRerunResult(rerunResult *TaskResultGroup) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.reruns == nil {
+		g.reruns = map[string][]*SubTaskResult{}
+	}
+	for _, result := range rerunResult.results {
+		for _, group := range result.groups {
+			for _, subTaskResult := range group.results {
+				g.reruns[subTaskResult.Name] = append(g.reruns[subTaskResult.Name], subTaskResult)
+			}
+		}
+	}
+}
+
+// classification classifies the failed key by the results of re-runs.
+// If the key hasn't been re-run, returns empty string.
+func (g *TaskResultGroup) classification(name string) FlakyClassification {
+	reruns := g.reruns[name]
+	if len(reruns) == 0 {
+		return ""
+	}
+	for _, rerun := range reruns {
+		if rerun.Status == TaskResultSuccess {
+			return FlakyClassificationFlaky
+		}
+	}
+	return FlakyClassificationBroken
+}
+
+// ClassifiedNum returns the number of failed keys classified as the classification by flakyDetection.
+func (g *TaskResultGroup) ClassifiedNum(classification FlakyClassification) int {
+	num := 0
+	for _, result := range g.results {
+		for _, group := range result.groups {
+			for _, subTaskResult := range group.results {
+				if subTaskResult.Status.failed() && g.classification(subTaskResult.Name) == classification {
+					num++
+				}
+			}
+		}
+	}
+	return num
+}
+
+func (g *TaskResultGroup) attemptResults(result *SubTaskResult) []*ReportAttemptResult {
+	attempts := make([]*ReportAttemptResult, 0, len(g.reruns[result.Name])+1)
+	for _, r := range append([]*SubTaskResult{result}, g.reruns[result.Name]...) {
+		attempts = append(attempts, &ReportAttemptResult{
+			Status:         r.Status.ToResultStatus(),
+			ElapsedTimeSec: int64(r.ElapsedTime.Seconds()),
+			Log:            string(r.Out),
+		})
+	}
+	return attempts
+}
+
 func (g *TaskResultGroup) add(result *TaskResult) {
 	g.mu.Lock()
 	g.results = append(g.results, result)
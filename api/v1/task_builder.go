@@ -58,10 +58,14 @@ func (b *TaskBuilder) BuildWithKey(ctx context.Context, step Step, strategyKey *
 	if mainContainer.Name == "" {
 		return nil, fmt.Errorf("kubetest: main container name must be specified")
 	}
-	createJob := func(ctx context.Context) (Job, error) {
-		return b.buildJob(ctx, mainContainer, tmpl, strategyKey)
+	retryPolicy, err := newTaskRetryPolicy(step.GetRetryPolicy())
+	if err != nil {
+		return nil, err
 	}
-	job, err := createJob(ctx)
+	createJob := func(ctx context.Context, strategyKey *StrategyKey) (Job, error) {
+		return b.buildJob(ctx, mainContainer, tmpl, strategyKey, retryPolicy)
+	}
+	job, err := createJob(ctx, strategyKey)
 	if err != nil {
 		return nil, err
 	}
@@ -106,9 +110,18 @@ func (b *TaskBuilder) BuildWithKey(ctx context.Context, step Step, strategyKey *
 		}
 		return nil
 	}
-	var onFinishSubTask func(*SubTask)
+	var (
+		onFinishSubTask func(*SubTask, *SubTaskResult)
+		timeout         time.Duration
+	)
 	if strategyKey != nil {
+		// the timeout of the step that has strategy is the time limit of all tasks. so TaskGroup handles it.
 		onFinishSubTask = strategyKey.OnFinishSubTask
+	} else {
+		timeout, err = parseDuration(step.GetTimeout())
+		if err != nil {
+			return nil, err
+		}
 	}
 	return &Task{
 		Name:              step.GetName(),
@@ -118,12 +131,14 @@ func (b *TaskBuilder) BuildWithKey(ctx context.Context, step Step, strategyKey *
 		strategyKey:       strategyKey,
 		mainContainerName: mainContainer.Name,
 		createJob:         createJob,
+		timeout:           timeout,
+		retryPolicy:       retryPolicy,
 	}, nil
 }
 
-func (b *TaskBuilder) buildJob(ctx context.Context, mainContainer TestJobContainer, tmpl TestJobTemplateSpec, strategyKey *StrategyKey) (Job, error) {
+func (b *TaskBuilder) buildJob(ctx context.Context, mainContainer TestJobContainer, tmpl TestJobTemplateSpec, strategyKey *StrategyKey, retryPolicy *taskRetryPolicy) (Job, error) {
 	spec := *tmpl.Spec.DeepCopy()
-	b.addContainersByStrategyKey(&spec, mainContainer, strategyKey)
+	keyContainers := b.addContainersByStrategyKey(&spec, mainContainer, strategyKey)
 	buildCtx := &TaskBuildContext{
 		initContainers: newTaskContainerGroup(spec.InitContainers, spec.Volumes),
 		containers:     newTaskContainerGroup(spec.Containers, spec.Volumes),
@@ -157,10 +172,14 @@ func (b *TaskBuilder) buildJob(ctx context.Context, mainContainer TestJobContain
 				Spec:       podSpec,
 			},
 		},
-	}, buildCtx.containerNameToInstalledAgentPathMap(), mainContainer.Agent)
+	}, buildCtx.containerNameToInstalledAgentPathMap(), mainContainer.Agent, keyContainers)
 	if err != nil {
 		return nil, err
 	}
+	if retryPolicy.retryable(RetryConditionImagePullBackOff) {
+		// the pod that can't pull the image stays pending, so it needs to be detected to retry.
+		job.FailOnImagePullBackOff()
+	}
 	if buildCtx.needsToPreInit() {
 		callback, err := b.preInitCallback(ctx, buildCtx)
 		if err != nil {
@@ -332,19 +351,33 @@ func (b *TaskBuilder) mountReport(ctx context.Context, taskContainer *TaskContai
 	return nil
 }
 
-func (b *TaskBuilder) addContainersByStrategyKey(podSpec *TestJobPodSpec, mainContainer TestJobContainer, strategyKey *StrategyKey) {
+// addContainersByStrategyKey replaces the main container with the containers for each key or worker.
+// Returns the map from the name of the container that runs keys to whether it's the worker container.
+func (b *TaskBuilder) addContainersByStrategyKey(podSpec *TestJobPodSpec, mainContainer TestJobContainer, strategyKey *StrategyKey) map[string]bool {
 	if strategyKey == nil {
-		return
+		return map[string]bool{mainContainer.Name: false}
 	}
+	keyContainers := map[string]bool{}
 	containers := []TestJobContainer{}
-	for idx, key := range strategyKey.Keys {
+	for idx := 0; idx < strategyKey.WorkerNum; idx++ {
+		// worker container has the key env with empty value. the value is passed each time it pulls the key from the queue.
 		container := *mainContainer.DeepCopy()
 		container.Name += fmt.Sprintf("%d-%d", strategyKey.ConcurrentIdx, idx)
 		container.Env = append(container.Env, corev1.EnvVar{
-			Name:  strategyKey.Env,
-			Value: key,
+			Name: strategyKey.Env,
 		})
 		containers = append(containers, container)
+		keyContainers[container.Name] = true
+	}
+	for idx, key := range strategyKey.Keys {
+		container := *mainContainer.DeepCopy()
+		container.Name += fmt.Sprintf("%d-%d", strategyKey.ConcurrentIdx, idx)
+		container.Env = append(container.Env, strategyKey.EnvVars(key)...)
+		if resources := strategyKey.Resources[key]; resources != nil {
+			container.Resources = *resources.DeepCopy()
+		}
+		containers = append(containers, container)
+		keyContainers[container.Name] = false
 	}
 	sideCarContainers := []TestJobContainer{}
 	for _, container := range podSpec.Containers {
@@ -354,6 +387,7 @@ func (b *TaskBuilder) addContainersByStrategyKey(podSpec *TestJobPodSpec, mainCo
 		sideCarContainers = append(sideCarContainers, container)
 	}
 	podSpec.Containers = append(sideCarContainers, containers...)
+	return keyContainers
 }
 
 func (b *TaskBuilder) preInitContainer(buildCtx *TaskBuildContext) TestJobContainer {
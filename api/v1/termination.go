@@ -0,0 +1,162 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/goccy/kubejob"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// signalExitCodeBase the shell reports the command killed by the signal as 128 + the signal number.
+	signalExitCodeBase = 128
+
+	terminationReasonDeadlineExceeded = "DeadlineExceeded"
+)
+
+// signals the signals that usually terminate the test command.
+// desc is the text of the signal written by the error of os/exec.
+var signals = []struct {
+	num  int
+	name string
+	desc string
+}{
+	{num: 1, name: "SIGHUP", desc: "hangup"},
+	{num: 2, name: "SIGINT", desc: "interrupt"},
+	{num: 3, name: "SIGQUIT", desc: "quit"},
+	{num: 4, name: "SIGILL", desc: "illegal instruction"},
+	{num: 6, name: "SIGABRT", desc: "aborted"},
+	{num: 7, name: "SIGBUS", desc: "bus error"},
+	{num: 8, name: "SIGFPE", desc: "floating point exception"},
+	{num: 9, name: "SIGKILL", desc: "killed"},
+	{num: 11, name: "SIGSEGV", desc: "segmentation fault"},
+	{num: 13, name: "SIGPIPE", desc: "broken pipe"},
+	{num: 14, name: "SIGALRM", desc: "alarm clock"},
+	{num: 15, name: "SIGTERM", desc: "terminated"},
+}
+
+func signalNameByNum(num int) string {
+	for _, sig := range signals {
+		if sig.num == num {
+			return sig.name
+		}
+	}
+	return fmt.Sprintf("SIG%d", num)
+}
+
+// exitStatus the exit status of the command.
+type exitStatus struct {
+	code   int
+	signal string
+}
+
+// exitStatusByError returns the exit status of the command from the error.
+// If the error isn't caused by the exit status of the command, returns false.
+func exitStatusByError(err error) (*exitStatus, bool) {
+	var (
+		cmdErr    *kubejob.CommandError
+		failedJob *kubejob.FailedJob
+		exitErr   *exec.ExitError
+	)
+	switch {
+	case errors.As(err, &cmdErr):
+		if cmdErr.Message != "" {
+			// the command run by kubetest-agent reports the error message of os/exec.
+			return exitStatusByMessage(cmdErr.Message)
+		}
+		for _, e := range []error{cmdErr.ReaderErr, cmdErr.WriterErr} {
+			if codeErr, ok := e.(interface{ ExitStatus() int }); ok {
+				return newExitStatus(codeErr.ExitStatus(), ""), true
+			}
+		}
+	case errors.As(err, &failedJob):
+		// FailedJob doesn't unwrap the reason.
+		return exitStatusByError(failedJob.Reason)
+	case errors.As(err, &exitErr):
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			num := int(status.Signal())
+			return &exitStatus{code: signalExitCodeBase + num, signal: signalNameByNum(num)}, true
+		}
+		return newExitStatus(exitErr.ExitCode(), ""), true
+	}
+	return nil, false
+}
+
+// exitStatusByMessage parses the message like "exit status 1" or "signal: killed".
+func exitStatusByMessage(msg string) (*exitStatus, bool) {
+	if code, err := strconv.Atoi(strings.TrimPrefix(msg, "exit status ")); err == nil {
+		return newExitStatus(code, ""), true
+	}
+	if desc := strings.TrimPrefix(msg, "signal: "); desc != msg {
+		for _, sig := range signals {
+			if sig.desc == desc {
+				return &exitStatus{code: signalExitCodeBase + sig.num, signal: sig.name}, true
+			}
+		}
+		return &exitStatus{signal: desc}, true
+	}
+	return nil, false
+}
+
+func newExitStatus(code int, signal string) *exitStatus {
+	if signal == "" && code > signalExitCodeBase {
+		// exit code like 255 isn't caused by the signal, so only the known signals are regarded.
+		for _, sig := range signals {
+			if sig.num == code-signalExitCodeBase {
+				signal = sig.name
+			}
+		}
+	}
+	return &exitStatus{code: code, signal: signal}
+}
+
+// setTermination records how the command of the failed result was terminated.
+// The termination reason and the restart count are taken from the latest status of the pod, and the pod of the result is replaced with it.
+func (r *SubTaskResult) setTermination(ctx context.Context, exec JobExecutor) {
+	if status, exists := exitStatusByError(r.Err); exists {
+		r.ExitCode = status.code
+		r.Signal = status.signal
+	}
+	pod, err := exec.LatestPod(ctx)
+	if err != nil {
+		LoggerFromContext(ctx).Debug("failed to get pod status of %s: %s", r.Name, err.Error())
+	}
+	if pod != nil {
+		// the pod taken when the pod starts running doesn't have the reason like Evicted, so it's used to decide whether the pod was lost.
+		r.Pod = pod
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name != r.Container.Name {
+				continue
+			}
+			r.RestartCount = status.RestartCount
+			r.TerminationReason = terminationReason(status)
+		}
+		if r.TerminationReason == "" {
+			// the pod level reason like DeadlineExceeded or Evicted.
+			r.TerminationReason = pod.Status.Reason
+		}
+	}
+	if r.TerminationReason == "" && r.Status == TaskResultTimeout {
+		r.TerminationReason = terminationReasonDeadlineExceeded
+	}
+}
+
+func terminationReason(status corev1.ContainerStatus) string {
+	if terminated := status.State.Terminated; terminated != nil && terminated.Reason != "" {
+		return terminated.Reason
+	}
+	// the container that has been restarted keeps the reason of the last termination ( e.g. OOMKilled ).
+	if terminated := status.LastTerminationState.Terminated; terminated != nil {
+		return terminated.Reason
+	}
+	return ""
+}
@@ -0,0 +1,111 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/goccy/kubejob"
+	corev1 "k8s.io/api/core/v1"
+)
+
+type exitStatusTestError struct {
+	code int
+}
+
+func (e *exitStatusTestError) Error() string   { return "command terminated" }
+func (e *exitStatusTestError) ExitStatus() int { return e.code }
+
+type terminationTestExecutor struct {
+	retryTestExecutor
+	pod *corev1.Pod
+}
+
+func (e *terminationTestExecutor) LatestPod(_ context.Context) (*corev1.Pod, error) {
+	return e.pod, nil
+}
+
+func TestTermination(t *testing.T) {
+	t.Run("ExitStatusByError", func(t *testing.T) {
+		localErr := func(script string) error {
+			err := exec.Command("sh", "-c", script).Run()
+			if err == nil {
+				t.Fatalf("expected error by %q", script)
+			}
+			return err
+		}
+		for _, test := range []struct {
+			name   string
+			err    error
+			code   int
+			signal string
+			exists bool
+		}{
+			{name: "agent exit status", err: &kubejob.CommandError{Message: "exit status 1"}, code: 1, exists: true},
+			{name: "agent signal", err: &kubejob.CommandError{Message: "signal: killed"}, code: 137, signal: "SIGKILL", exists: true},
+			{name: "exec exit status", err: &kubejob.FailedJob{Reason: &kubejob.CommandError{ReaderErr: &exitStatusTestError{code: 139}}}, code: 139, signal: "SIGSEGV", exists: true},
+			{name: "exit status that isn't signal", err: &kubejob.CommandError{Message: "exit status 255"}, code: 255, exists: true},
+			{name: "local exit status", err: localErr("exit 3"), code: 3, exists: true},
+			{name: "local signal", err: localErr("kill -TERM $$"), code: 143, signal: "SIGTERM", exists: true},
+			{name: "connection error", err: &kubejob.CommandError{ReaderErr: errors.New("connection lost")}},
+			{name: "unknown error", err: errors.New("error")},
+		} {
+			status, exists := exitStatusByError(test.err)
+			if exists != test.exists {
+				t.Fatalf("%s: failed to get exit status: expected %v but got %v", test.name, test.exists, exists)
+			}
+			if !exists {
+				continue
+			}
+			if status.code != test.code || status.signal != test.signal {
+				t.Fatalf("%s: unexpected exit status: expected (%d, %q) but got (%d, %q)", test.name, test.code, test.signal, status.code, status.signal)
+			}
+		}
+	})
+	t.Run("SetTermination", func(t *testing.T) {
+		ctx := WithLogger(context.Background(), NewLogger(os.Stdout, LogLevelDebug))
+		container := corev1.Container{Name: "test"}
+		for _, test := range []struct {
+			name         string
+			result       *SubTaskResult
+			pod          *corev1.Pod
+			reason       string
+			restartCount int32
+		}{
+			{
+				name:   "oom killed",
+				result: &SubTaskResult{Status: TaskResultFailure, Err: &kubejob.CommandError{Message: "signal: killed"}, Container: container},
+				pod: &corev1.Pod{Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+					Name:                 "test",
+					RestartCount:         1,
+					LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}},
+				}}}},
+				reason:       "OOMKilled",
+				restartCount: 1,
+			},
+			{
+				name:   "pod deadline",
+				result: &SubTaskResult{Status: TaskResultFailure, Err: errors.New("error"), Container: container},
+				pod:    &corev1.Pod{Status: corev1.PodStatus{Reason: "DeadlineExceeded"}},
+				reason: "DeadlineExceeded",
+			},
+			{
+				name:   "key timeout",
+				result: &SubTaskResult{Status: TaskResultTimeout, Err: errors.New("error"), Container: container},
+				reason: "DeadlineExceeded",
+			},
+			{
+				name:   "assertion failure",
+				result: &SubTaskResult{Status: TaskResultFailure, Err: &kubejob.CommandError{Message: "exit status 1"}, Container: container},
+			},
+		} {
+			test.result.setTermination(ctx, &terminationTestExecutor{pod: test.pod})
+			if test.result.TerminationReason != test.reason || test.result.RestartCount != test.restartCount {
+				t.Fatalf("%s: unexpected termination: expected (%q, %d) but got (%q, %d)",
+					test.name, test.reason, test.restartCount, test.result.TerminationReason, test.result.RestartCount)
+			}
+		}
+	})
+}
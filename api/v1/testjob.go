@@ -16,3 +16,22 @@ func (j *TestJob) SetStaticStrategyKeys(keys []string) error {
 	j.Spec.MainStep.Strategy.Key.Source.Static = keys
 	return nil
 }
+
+func (j *TestJob) SetShard(index, total int) error {
+	if j.Spec.MainStep.Strategy == nil {
+		return fmt.Errorf("kubetest: spec.mainStep.strategy is undefined")
+	}
+	j.Spec.MainStep.Strategy.Shard = &Shard{
+		Index: index,
+		Total: total,
+	}
+	return nil
+}
+
+func (j *TestJob) SetPreviousReport(path string) error {
+	if j.Spec.MainStep.Strategy == nil {
+		return fmt.Errorf("kubetest: spec.mainStep.strategy is undefined")
+	}
+	j.Spec.MainStep.Strategy.Scheduler.PreviousReport = path
+	return nil
+}
@@ -19,6 +19,10 @@ type TestJobSpec struct {
 	// so the resources of kubernetes cluster can be used efficiently.
 	// +optional
 	PreSteps []PreStep `json:"preSteps,omitempty"`
+	// MaxConcurrentPreSteps maximum number of preSteps running at the same time ( default: unlimited ).
+	// If it's greater than 1, the preSteps run as DAG even if no preStep specifies dependsOn.
+	// +optional
+	MaxConcurrentPreSteps int `json:"maxConcurrentPreSteps,omitempty"`
 	// MainStep defines the behavior when running the main task. This step can be distributed.
 	MainStep MainStep `json:"mainStep"`
 	// PostSteps defines post-processing to export artifacts.
@@ -30,6 +34,11 @@ type TestJobSpec struct {
 	// Log extend parameter to output log.
 	// +optional
 	Log LogSpec `json:"log,omitempty"`
+	// Deadline time limit of preSteps and mainStep measured from the start of the job ( e.g. 1h ).
+	// When it is reached, the running containers are stopped and the keys that never ran are recorded as skipped.
+	// After that, log and report are written, postSteps run and artifacts are exported as usual.
+	// +optional
+	Deadline string `json:"deadline,omitempty"`
 }
 
 // RepositorySpec describes the specification of repository.
@@ -96,6 +105,23 @@ type GitHubTokenSource corev1.SecretKeySelector
 type PreStep struct {
 	Name     string              `json:"name"`
 	Template TestJobTemplateSpec `json:"template"`
+	// Timeout time limit of the step ( e.g. 10m ). The running containers are stopped when it expires.
+	Timeout string `json:"timeout,omitempty"`
+	// RetryPolicy how to retry the pod that fails to run.
+	RetryPolicy *RetryPolicy `json:"retryPolicy,omitempty"`
+	// Strategy distributes the step by keys in the same way as mainStep.
+	// The artifacts of the containers of all keys are merged into the artifact of the same name.
+	// +optional
+	Strategy *Strategy `json:"strategy,omitempty"`
+	// DependsOn names of preSteps that must finish before this step.
+	// The preSteps run as DAG, and the step can use only the artifacts of its dependencies.
+	// The preStep without dependsOn starts immediately as the root of DAG.
+	// If no preStep specifies dependsOn and maxConcurrentPreSteps isn't greater than 1, the preSteps run in order of the list.
+	DependsOn []string `json:"dependsOn,omitempty"`
+	// Cache skips the step if the artifacts for the cache key are already stored, and uses the cached artifacts.
+	// Otherwise the step runs and its artifacts are stored by the key.
+	// +optional
+	Cache *PreStepCache `json:"cache,omitempty"`
 }
 
 func (s *PreStep) GetName() string {
@@ -110,12 +136,52 @@ func (s *PreStep) GetTemplate() TestJobTemplateSpec {
 	return s.Template
 }
 
+func (s *PreStep) GetTimeout() string {
+	return s.Timeout
+}
+
+func (s *PreStep) GetRetryPolicy() *RetryPolicy {
+	return s.RetryPolicy
+}
+
+func (s *PreStep) GetStrategy() *Strategy {
+	return s.Strategy
+}
+
+// PreStepCache describes the cache of the artifacts of preStep.
+type PreStepCache struct {
+	// Key template of the cache key written in text/template.
+	// `{{ commit "repo" }}` is expanded to the commit hash of HEAD of the repository, and
+	// `{{ hashFiles "repo" "go.sum" "**/go.mod" }}` is expanded to the hash of the contents of the files that match glob patterns.
+	// ( e.g. gomod-{{ commit "repo" }}-{{ hashFiles "repo" "go.sum" }} )
+	Key string `json:"key"`
+	// Store where the cached artifacts are stored.
+	Store CacheStore `json:"store"`
+}
+
+// CacheStore describes the store of the cache. One of the stores must be specified.
+type CacheStore struct {
+	// Local stores the cache in the local directory.
+	Local *LocalCacheStore `json:"local,omitempty"`
+}
+
+// LocalCacheStore describes the local directory to store the cache.
+type LocalCacheStore struct {
+	// Path to the directory. To share the cache between the runs, specify the mount path of PersistentVolumeClaim.
+	Path string `json:"path"`
+}
+
 // MainStep defines main process
 type MainStep struct {
 	// Strategy strategy for distributed task
 	// +optional
 	Strategy *Strategy           `json:"strategy,omitempty"`
 	Template TestJobTemplateSpec `json:"template"`
+	// Timeout time limit of the step ( e.g. 10m ). If strategy is used, it's the time limit of all keys.
+	// The running containers are stopped when it expires.
+	Timeout string `json:"timeout,omitempty"`
+	// RetryPolicy how to retry the pod that fails to run.
+	RetryPolicy *RetryPolicy `json:"retryPolicy,omitempty"`
 }
 
 func (s *MainStep) GetName() string {
@@ -130,10 +196,84 @@ func (s *MainStep) GetTemplate() TestJobTemplateSpec {
 	return s.Template
 }
 
+func (s *MainStep) GetTimeout() string {
+	return s.Timeout
+}
+
+func (s *MainStep) GetRetryPolicy() *RetryPolicy {
+	return s.RetryPolicy
+}
+
+func (s *MainStep) GetStrategy() *Strategy {
+	return s.Strategy
+}
+
+// RetryPolicy describes how to retry the pod that fails to run by the reason of the cluster.
+// The pod is recreated and all containers of it are run again.
+type RetryPolicy struct {
+	// Count max number of retries. If zero, doesn't retry.
+	Count int `json:"count"`
+	// Backoff initial interval of exponential backoff between retries ( e.g. 1s ) ( default: 1s ).
+	Backoff string `json:"backoff,omitempty"`
+	// On retryable conditions ( default: pendingTimeout, preInitFailure, eviction and nodeLost ).
+	On []RetryCondition `json:"on,omitempty"`
+}
+
+// RetryCondition the reason of the pod failure to retry.
+type RetryCondition string
+
+const (
+	// RetryConditionPendingTimeout the pod doesn't move to running phase within the timeout.
+	RetryConditionPendingTimeout RetryCondition = "pendingTimeout"
+	// RetryConditionPreInitFailure failed to run preinit container that prepares repository, token and artifact.
+	RetryConditionPreInitFailure RetryCondition = "preInitFailure"
+	// RetryConditionEviction the pod is evicted.
+	RetryConditionEviction RetryCondition = "eviction"
+	// RetryConditionImagePullBackOff the container of the pod can't pull the image.
+	RetryConditionImagePullBackOff RetryCondition = "imagePullBackOff"
+	// RetryConditionNodeLost the node running the pod is lost.
+	RetryConditionNodeLost RetryCondition = "nodeLost"
+)
+
 // PostStep defines post-processing to export artifacts.
 type PostStep struct {
 	Name     string              `json:"name"`
 	Template TestJobTemplateSpec `json:"template"`
+	// Timeout time limit of the step ( e.g. 10m ). The running containers are stopped when it expires.
+	Timeout string `json:"timeout,omitempty"`
+	// RetryPolicy how to retry the pod that fails to run.
+	RetryPolicy *RetryPolicy `json:"retryPolicy,omitempty"`
+	// Strategy distributes the step by keys in the same way as mainStep.
+	// +optional
+	Strategy *Strategy `json:"strategy,omitempty"`
+	// When condition to run the step.
+	// If not specified, the step runs when preSteps and mainStep finish without error regardless of the test status.
+	When PostStepCondition `json:"when,omitempty"`
+}
+
+// PostStepCondition condition to run the postStep.
+type PostStepCondition string
+
+const (
+	// PostStepConditionAlways runs the step even if preSteps or mainStep returned error.
+	PostStepConditionAlways PostStepCondition = "always"
+	// PostStepConditionOnSuccess runs the step only if the status of the test is success.
+	PostStepConditionOnSuccess PostStepCondition = "onSuccess"
+	// PostStepConditionOnFailure runs the step only if the test failed or preSteps or mainStep returned error.
+	PostStepConditionOnFailure PostStepCondition = "onFailure"
+)
+
+// shouldRun reports whether the step runs by the status of the test and the error of preSteps and mainStep.
+func (c PostStepCondition) shouldRun(status ResultStatus, stepErr error) bool {
+	switch c {
+	case PostStepConditionAlways:
+		return true
+	case PostStepConditionOnSuccess:
+		return stepErr == nil && status == ResultStatusSuccess
+	case PostStepConditionOnFailure:
+		return stepErr != nil || status != ResultStatusSuccess
+	}
+	return stepErr == nil
 }
 
 func (s *PostStep) GetName() string {
@@ -148,6 +288,18 @@ func (s *PostStep) GetTemplate() TestJobTemplateSpec {
 	return s.Template
 }
 
+func (s *PostStep) GetTimeout() string {
+	return s.Timeout
+}
+
+func (s *PostStep) GetRetryPolicy() *RetryPolicy {
+	return s.RetryPolicy
+}
+
+func (s *PostStep) GetStrategy() *Strategy {
+	return s.Strategy
+}
+
 // TestJobTemplateSpec
 type TestJobTemplateSpec struct {
 	// ObjectMeta standard object's metadata.
@@ -261,6 +413,8 @@ const (
 	ResultStatusSuccess ResultStatus = "success"
 	ResultStatusFailure              = "failure"
 	ResultStatusError                = "error"
+	ResultStatusSkipped              = "skipped"
+	ResultStatusTimeout              = "timeout"
 )
 
 type Report struct {
@@ -271,14 +425,85 @@ type Report struct {
 	SuccessNum     int               `json:"successNum"`
 	FailureNum     int               `json:"failureNum"`
 	UnknownNum     int               `json:"unknownNum,omitempty"`
+	SkippedNum     int               `json:"skippedNum,omitempty"`
+	TimeoutNum     int               `json:"timeoutNum,omitempty"`
 	Details        []*ReportDetail   `json:"details"`
 	ExtParam       map[string]string `json:"ext,omitempty"`
+	// Shard the shard that ran the keys of the report.
+	Shard *Shard `json:"shard,omitempty"`
+	// RetryNum total number of times the pods of all steps were recreated by retryPolicy.
+	RetryNum int `json:"retryNum,omitempty"`
+	// QuarantinedFailureNum number of quarantined keys that failed. They aren't counted by FailureNum.
+	QuarantinedFailureNum int `json:"quarantinedFailureNum,omitempty"`
+	// FlakyNum number of failed keys classified as flaky by flakyDetection.
+	FlakyNum int `json:"flakyNum,omitempty"`
+	// BrokenNum number of failed keys classified as broken by flakyDetection.
+	BrokenNum int `json:"brokenNum,omitempty"`
 }
 
+// FlakyClassification classification of the failed key by flakyDetection.
+type FlakyClassification string
+
+const (
+	// FlakyClassificationFlaky the failed key passed at least once by re-runs.
+	FlakyClassificationFlaky FlakyClassification = "flaky"
+	// FlakyClassificationBroken the failed key failed in all re-runs.
+	FlakyClassificationBroken FlakyClassification = "broken"
+)
+
 type ReportDetail struct {
 	Status         ResultStatus `json:"status"`
 	Name           string       `json:"name"`
 	ElapsedTimeSec int64        `json:"elapsedTimeSec"`
+	// ElapsedTimeMsec elapsed time of the key in milliseconds. If the key is run repeatedly, the median of them.
+	ElapsedTimeMsec int64 `json:"elapsedTimeMsec,omitempty"`
+	// ElapsedTimeStats statistics of the elapsed time of the key run repeatedly by strategy.repeat.
+	ElapsedTimeStats *ReportElapsedTimeStats `json:"elapsedTimeStats,omitempty"`
+	// Attempts number of times the task was executed ( greater than 1 if retested ).
+	Attempts int `json:"attempts,omitempty"`
+	// Retries number of times the pod that runs the key was recreated by retryPolicy.
+	Retries int `json:"retries,omitempty"`
+	// ExitCode exit code of the failed command. If the command is killed by the signal, 128 + the signal number.
+	ExitCode int `json:"exitCode,omitempty"`
+	// Signal name of the signal that killed the command ( e.g. SIGKILL ).
+	Signal string `json:"signal,omitempty"`
+	// TerminationReason reason of the container termination ( e.g. OOMKilled, Error, DeadlineExceeded ).
+	TerminationReason string `json:"terminationReason,omitempty"`
+	// RestartCount number of times the container has been restarted.
+	RestartCount int32 `json:"restartCount,omitempty"`
+	// PeakMemoryBytes peak memory usage of the key.
+	PeakMemoryBytes int64 `json:"peakMemoryBytes,omitempty"`
+	// CPUTimeMsec CPU time consumed by the key.
+	CPUTimeMsec int64 `json:"cpuTimeMsec,omitempty"`
+	// Quarantined whether the key is quarantined by strategy.quarantine.
+	Quarantined bool `json:"quarantined,omitempty"`
+	// SuccessStreak number of consecutive successful runs of the quarantined key.
+	SuccessStreak int `json:"successStreak,omitempty"`
+	// ReleaseCandidate whether the quarantined key has passed enough consecutive runs to be released.
+	ReleaseCandidate bool `json:"releaseCandidate,omitempty"`
+	// Classification classification of the failed key by flakyDetection.
+	Classification FlakyClassification `json:"classification,omitempty"`
+	// AttemptResults results of the first run and each re-run of the failed key by flakyDetection.
+	AttemptResults []*ReportAttemptResult `json:"attemptResults,omitempty"`
+}
+
+// ReportElapsedTimeStats statistics of the elapsed time of the runs of the key.
+// The skipped runs aren't included.
+type ReportElapsedTimeStats struct {
+	// Runs number of runs measured.
+	Runs       int   `json:"runs"`
+	MinMsec    int64 `json:"minMsec"`
+	MedianMsec int64 `json:"medianMsec"`
+	P95Msec    int64 `json:"p95Msec"`
+	StddevMsec int64 `json:"stddevMsec"`
+}
+
+// ReportAttemptResult result of each execution of the key.
+type ReportAttemptResult struct {
+	Status         ResultStatus `json:"status"`
+	ElapsedTimeSec int64        `json:"elapsedTimeSec"`
+	// Log output of the command.
+	Log string `json:"log,omitempty"`
 }
 
 // ReportVolumeSource
@@ -333,13 +558,91 @@ type Strategy struct {
 	Key StrategyKeySpec `json:"key"`
 	// Scheduler
 	Scheduler Scheduler `json:"scheduler"`
-	// Restart testing for failed tests
+	// Retest re-run failed keys once more by new pods after all keys are finished.
+	// Only the final outcome of each key is counted in the report.
 	Retest bool `json:"retest,omitempty"`
+	// FailFast cancels the remaining keys as soon as the first key fails.
+	// The running keys are stopped, and the keys that haven't finished are recorded as skipped.
+	FailFast bool `json:"failFast,omitempty"`
+	// KeyTimeout time limit of each key ( e.g. 1m ).
+	// The container of the expired key is stopped, and the key is recorded as timeout. It cannot be used with queue mode.
+	KeyTimeout string `json:"keyTimeout,omitempty"`
+	// Shard runs only the part of keys to split them across multiple kubetest invocations.
+	// The keys are partitioned by the hash of the key name, so the same key always belongs to the same shard.
+	Shard *Shard `json:"shard,omitempty"`
+	// Impact runs only the keys affected by the changed files between the merge base and HEAD of the merged repository.
+	Impact *StrategyImpact `json:"impact,omitempty"`
+	// Quarantine runs known flaky keys without affecting the status of the report.
+	Quarantine *StrategyQuarantine `json:"quarantine,omitempty"`
+	// FlakyDetection re-runs failed keys to classify them as flaky or broken. Cannot be used with Retest.
+	FlakyDetection *StrategyFlakyDetection `json:"flakyDetection,omitempty"`
+	// Repeat runs every key this number of times across containers ( e.g. for benchmarks ).
+	// The report has one detail for each key with the statistics of the elapsed time.
+	Repeat int `json:"repeat,omitempty"`
+}
+
+// StrategyFlakyDetection re-runs each failed key by new pods until it passes.
+// The key that passes once is classified as flaky, and the key that fails in all re-runs is classified as broken.
+type StrategyFlakyDetection struct {
+	// Count maximum number of re-runs of each failed key ( default: 3 ).
+	Count int `json:"count,omitempty"`
+}
+
+// StrategyQuarantine specifies the quarantined keys.
+// The failures of them are reported separately, and they don't change the status of the report.
+type StrategyQuarantine struct {
+	// Keys glob patterns of quarantined keys.
+	Keys []string `json:"keys,omitempty"`
+	// ConfigMap reads glob patterns of quarantined keys from ConfigMap.
+	ConfigMap *StrategyConfigMapKeySource `json:"configMap,omitempty"`
+	// Repo reads glob patterns of quarantined keys from the file of repository.
+	Repo *StrategyRepositoryKeySource `json:"repo,omitempty"`
+	// ReleaseThreshold the quarantined key that passes this number of consecutive runs is flagged as the candidate for release ( default: 10 ).
+	// The consecutive runs are counted by scheduler.previousReport.
+	ReleaseThreshold int `json:"releaseThreshold,omitempty"`
+}
+
+// StrategyImpact selects keys by the changed files of the repository.
+type StrategyImpact struct {
+	// Repo this must match the Name of a RepositorySpec that specifies merge.
+	Repo string `json:"repo"`
+	// Rules mapping from the changed file path to keys.
+	Rules []StrategyImpactRule `json:"rules"`
+	// Fallback keys always included regardless of the changed files ( glob pattern ).
+	Fallback []string `json:"fallback,omitempty"`
+}
+
+// StrategyImpactRule maps the changed file to keys.
+type StrategyImpactRule struct {
+	// Path glob pattern of the changed file path from the root of repository ( e.g. pkg/foo/**/*.go ).
+	Path string `json:"path"`
+	// Keys glob patterns of keys affected by the file that matches path.
+	Keys []string `json:"keys"`
+}
+
+// Shard specifies the part of keys run by the kubetest invocation.
+type Shard struct {
+	// Index index of the shard ( 0 <= index < total ).
+	Index int `json:"index"`
+	// Total number of shards.
+	Total int `json:"total"`
 }
 
 // StrategyKeySpec
 type StrategyKeySpec struct {
-	// Env name of env value for strategy key
+	// Env name of env value for strategy key.
+	// If matrix is used, the combined key name ( e.g. GO_VERSION=1.16,TEST_PACKAGE=foo ) is set to this env ( default: KUBETEST_STRATEGY_KEY ).
+	Env string `json:"env,omitempty"`
+	// Source
+	Source StrategyKeySource `json:"source,omitempty"`
+	// Matrix defines multiple dimensions of keys.
+	// The keys are expanded into the cartesian product of all dimensions, and every container gets the env values of all dimensions.
+	Matrix []StrategyMatrixDimension `json:"matrix,omitempty"`
+}
+
+// StrategyMatrixDimension
+type StrategyMatrixDimension struct {
+	// Env name of env value for this dimension
 	Env string `json:"env"`
 	// Source
 	Source StrategyKeySource `json:"source"`
@@ -351,6 +654,52 @@ type StrategyKeySource struct {
 	Static []string `json:"static,omitempty"`
 	// Dynamic
 	Dynamic *StrategyDynamicKeySource `json:"dynamic,omitempty"`
+	// ConfigMap read keys from the value of ConfigMap
+	ConfigMap *StrategyConfigMapKeySource `json:"configMap,omitempty"`
+	// Repo read keys from the file in the cloned repository
+	Repo *StrategyRepositoryKeySource `json:"repo,omitempty"`
+	// Artifact read keys from the artifact of preStep
+	Artifact *StrategyArtifactKeySource `json:"artifact,omitempty"`
+}
+
+// StrategyConfigMapKeySource
+type StrategyConfigMapKeySource struct {
+	// Name name of ConfigMap in the namespace of TestJob
+	Name string `json:"name"`
+	// Key key of ConfigMap data
+	Key string `json:"key"`
+	// Delimiter for strategy keys ( default: new line character ( \n ) )
+	Delim string `json:"delimiter,omitempty"`
+	// Filter filter got strategy keys ( use regular expression )
+	Filter string `json:"filter,omitempty"`
+	// Format format of the value ( default: text )
+	Format StrategyDynamicKeyFormat `json:"format,omitempty"`
+}
+
+// StrategyRepositoryKeySource
+type StrategyRepositoryKeySource struct {
+	// Name this must match the Name of a RepositorySpec.
+	Name string `json:"name"`
+	// Path relative file path from the root of repository
+	Path string `json:"path"`
+	// Delimiter for strategy keys ( default: new line character ( \n ) )
+	Delim string `json:"delimiter,omitempty"`
+	// Filter filter got strategy keys ( use regular expression )
+	Filter string `json:"filter,omitempty"`
+	// Format format of the file ( default: text )
+	Format StrategyDynamicKeyFormat `json:"format,omitempty"`
+}
+
+// StrategyArtifactKeySource
+type StrategyArtifactKeySource struct {
+	// Name this must match the Name of a ArtifactSpec of preStep.
+	Name string `json:"name"`
+	// Delimiter for strategy keys ( default: new line character ( \n ) )
+	Delim string `json:"delimiter,omitempty"`
+	// Filter filter got strategy keys ( use regular expression )
+	Filter string `json:"filter,omitempty"`
+	// Format format of the file ( default: text )
+	Format StrategyDynamicKeyFormat `json:"format,omitempty"`
 }
 
 type StrategyDynamicKeySource struct {
@@ -360,14 +709,57 @@ type StrategyDynamicKeySource struct {
 	Delim string `json:"delimiter,omitempty"`
 	// Filter filter got strategy keys ( use regular expression )
 	Filter string `json:"filter,omitempty"`
+	// Format format of the output of dynamic key task ( default: text ).
+	// If json is specified, the output is a JSON array or JSON Lines of key objects that has name, env, elapsedTimeSec and resources.
+	Format StrategyDynamicKeyFormat `json:"format,omitempty"`
 }
 
+// StrategyDynamicKeyFormat format of the output of dynamic key task
+type StrategyDynamicKeyFormat string
+
+const (
+	// StrategyDynamicKeyFormatText each key is separated by delimiter.
+	StrategyDynamicKeyFormatText StrategyDynamicKeyFormat = "text"
+	// StrategyDynamicKeyFormatJSON each key is the JSON object.
+	StrategyDynamicKeyFormatJSON StrategyDynamicKeyFormat = "json"
+)
+
+// SchedulerMode mode to assign keys to containers
+type SchedulerMode string
+
+const (
+	// SchedulerModeStatic assigns keys to each pod before starting it and runs one container per key.
+	SchedulerModeStatic SchedulerMode = "static"
+	// SchedulerModeQueue runs long-lived worker containers that pull the next key from the queue held by kubetest until the queue is empty.
+	SchedulerModeQueue SchedulerMode = "queue"
+)
+
 // Scheduler
 type Scheduler struct {
 	// MaxContainersPerPod maximum number of container per pod.
 	MaxContainersPerPod int `json:"maxContainersPerPod"`
 	// MaxConcurrentNumPerPod maximum number of concurrent per pod.
+	// If queue mode is used, this is the number of worker containers per pod.
 	MaxConcurrentNumPerPod int `json:"maxConcurrentNumPerPod"`
+	// Mode mode to assign keys to containers ( default: static ).
+	Mode SchedulerMode `json:"mode,omitempty"`
+	// PreviousReport path to the report file of the previous run.
+	// If specified, keys are distributed across pods so that the total elapsed time of each pod is balanced.
+	// The elapsed time of the key that isn't included in the report is regarded as the average.
+	PreviousReport string `json:"previousReport,omitempty"`
+	// KeysPerContainer number of keys packed into one container ( default: 1 ).
+	// The keys are passed to the env value joined by keyDelimiter.
+	KeysPerContainer int `json:"keysPerContainer,omitempty"`
+	// KeyDelimiter delimiter to join the keys packed into one container ( default: space ).
+	KeyDelimiter string `json:"keyDelimiter,omitempty"`
+	// ResultMarker prefix of the output line that reports the result of each key packed into one container.
+	// The line must be the form of `<resultMarker> <success|failure> <key>`.
+	// The key that isn't reported by the line has the same result as the container.
+	ResultMarker string `json:"resultMarker,omitempty"`
+	// MaxPodsPerStep maximum number of pods of the step running at the same time.
+	// The remaining pods of the step wait until one of the running pods finishes. If zero, all pods of the step run at the same time.
+	// It doesn't limit the pods of the other steps, so the pods of the steps running in parallel ( e.g. preSteps ) are counted separately.
+	MaxPodsPerStep int `json:"maxPodsPerStep,omitempty"`
 }
 
 // TestJobStatus defines the observed state of TestJob
@@ -0,0 +1,177 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+package v1
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ResourceUsageCommand the subcommand of kubetest-agent that runs the command and reports its resource usage.
+	ResourceUsageCommand = "measure"
+	// ResourceUsageProcessFlag the flag of ResourceUsageCommand to measure by rusage of the command instead of cgroup stats.
+	// The worker container runs multiple keys, so the cgroup stats of the container include the usage of the previous keys.
+	ResourceUsageProcessFlag = "--process"
+
+	// resourceUsageMarker prefix of the output line that reports the resource usage of the command.
+	resourceUsageMarker = "[kubetest-resource-usage]"
+
+	cgroupV2MemoryPeakPath = "/sys/fs/cgroup/memory.peak"
+	cgroupV2CPUStatPath    = "/sys/fs/cgroup/cpu.stat"
+	cgroupV1MemoryPeakPath = "/sys/fs/cgroup/memory/memory.max_usage_in_bytes"
+	cgroupV1CPUUsagePath   = "/sys/fs/cgroup/cpuacct/cpuacct.usage"
+)
+
+// ResourceUsage resource usage of the command.
+type ResourceUsage struct {
+	// PeakMemoryBytes peak memory usage. If measured by cgroup, it's the peak of the whole container.
+	PeakMemoryBytes int64
+	// CPUTime total of the user and system CPU time.
+	CPUTime time.Duration
+}
+
+func (u *ResourceUsage) String() string {
+	return fmt.Sprintf("%s %d %d", resourceUsageMarker, u.PeakMemoryBytes, u.CPUTime.Microseconds())
+}
+
+// parseResourceUsage removes the line reported by kubetest-agent from the output, and returns the resource usage of it.
+// If the output doesn't have the line, returns nil.
+func parseResourceUsage(out []byte) ([]byte, *ResourceUsage) {
+	// the line is written after the new line by MeasureResourceUsage.
+	idx := bytes.LastIndex(out, []byte("\n"+resourceUsageMarker))
+	if idx < 0 {
+		return out, nil
+	}
+	idx++
+	line := string(out[idx:])
+	if end := strings.IndexByte(line, '\n'); end >= 0 {
+		line = line[:end]
+	}
+	fields := strings.Fields(strings.TrimPrefix(line, resourceUsageMarker))
+	if len(fields) != 2 {
+		return out, nil
+	}
+	peakMemory, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return out, nil
+	}
+	cpuTime, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return out, nil
+	}
+	start := idx - 1
+	end := idx + len(line)
+	if end < len(out) {
+		end++
+	}
+	return append(out[:start:start], out[end:]...), &ResourceUsage{
+		PeakMemoryBytes: peakMemory,
+		CPUTime:         time.Duration(cpuTime) * time.Microsecond,
+	}
+}
+
+// processResourceUsage returns the resource usage of the exited process.
+func processResourceUsage(state *os.ProcessState) *ResourceUsage {
+	if state == nil {
+		return nil
+	}
+	return &ResourceUsage{
+		PeakMemoryBytes: peakMemoryBytes(state),
+		CPUTime:         state.UserTime() + state.SystemTime(),
+	}
+}
+
+// cgroupCPUTime returns CPU time consumed by the container so far.
+func cgroupCPUTime() (time.Duration, bool) {
+	if f, err := os.Open(cgroupV2CPUStatPath); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) != 2 || fields[0] != "usage_usec" {
+				continue
+			}
+			usec, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, false
+			}
+			return time.Duration(usec) * time.Microsecond, true
+		}
+		return 0, false
+	}
+	nsec, ok := readCgroupValue(cgroupV1CPUUsagePath)
+	return time.Duration(nsec), ok
+}
+
+// cgroupPeakMemoryBytes returns the peak memory usage of the container.
+func cgroupPeakMemoryBytes() (int64, bool) {
+	if peak, ok := readCgroupValue(cgroupV2MemoryPeakPath); ok {
+		return peak, true
+	}
+	return readCgroupValue(cgroupV1MemoryPeakPath)
+}
+
+func readCgroupValue(path string) (int64, bool) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// MeasureResourceUsage runs the command and writes its resource usage to w after the output of the command.
+// The resource usage is read from cgroup stats of the container. If they can't be read, uses rusage of the command.
+// Returns the exit code of the command. If the command is killed by the signal, returns 128 + the signal number.
+func MeasureResourceUsage(ctx context.Context, args []string, w io.Writer) (int, error) {
+	return measureResourceUsage(ctx, args, w, true)
+}
+
+// MeasureProcessResourceUsage is the same as MeasureResourceUsage, but uses only rusage of the command.
+// It's used for the container that runs multiple commands because cgroup stats can't be reset for each command.
+func MeasureProcessResourceUsage(ctx context.Context, args []string, w io.Writer) (int, error) {
+	return measureResourceUsage(ctx, args, w, false)
+}
+
+func measureResourceUsage(ctx context.Context, args []string, w io.Writer, useCgroup bool) (int, error) {
+	if len(args) == 0 {
+		return 0, fmt.Errorf("kubetest: command to measure resource usage must be specified")
+	}
+	startCPUTime, cgroupCPU := cgroupCPUTime()
+	cgroupCPU = cgroupCPU && useCgroup
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	cmd.Stdin = os.Stdin
+	runErr := cmd.Run()
+	var exitErr *exec.ExitError
+	if runErr != nil && !errors.As(runErr, &exitErr) {
+		return 0, fmt.Errorf("kubetest: failed to run %s: %w", args[0], runErr)
+	}
+	usage := processResourceUsage(cmd.ProcessState)
+	if endCPUTime, ok := cgroupCPUTime(); ok && cgroupCPU {
+		usage.CPUTime = endCPUTime - startCPUTime
+	}
+	if peak, ok := cgroupPeakMemoryBytes(); ok && useCgroup {
+		usage.PeakMemoryBytes = peak
+	}
+	fmt.Fprintf(w, "\n%s\n", usage)
+	if status, exists := exitStatusByError(runErr); exists {
+		return status.code, nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,122 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestResourceUsage(t *testing.T) {
+	t.Run("ParseResourceUsage", func(t *testing.T) {
+		usage := &ResourceUsage{PeakMemoryBytes: 1024, CPUTime: 1500 * time.Millisecond}
+		for _, test := range []struct {
+			name     string
+			out      string
+			expected string
+			usage    *ResourceUsage
+		}{
+			{name: "with output", out: "hello\n\n" + usage.String() + "\n", expected: "hello\n", usage: usage},
+			{name: "without output", out: "\n" + usage.String() + "\n", expected: "", usage: usage},
+			{name: "not reported", out: "hello\n", expected: "hello\n"},
+			{name: "invalid line", out: "hello\n" + resourceUsageMarker + " x\n", expected: "hello\n" + resourceUsageMarker + " x\n"},
+		} {
+			out, usage := parseResourceUsage([]byte(test.out))
+			if string(out) != test.expected {
+				t.Fatalf("%s: failed to remove resource usage line: expected %q but got %q", test.name, test.expected, out)
+			}
+			if test.usage == nil {
+				if usage != nil {
+					t.Fatalf("%s: expected no resource usage but got %v", test.name, usage)
+				}
+				continue
+			}
+			if usage == nil || *usage != *test.usage {
+				t.Fatalf("%s: failed to parse resource usage: expected %v but got %v", test.name, test.usage, usage)
+			}
+		}
+	})
+	t.Run("MeasureResourceUsage", func(t *testing.T) {
+		var out bytes.Buffer
+		exitCode, err := MeasureResourceUsage(context.Background(), []string{"sh", "-c", "echo hello; exit 3"}, &out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exitCode != 3 {
+			t.Fatalf("failed to get exit code: expected 3 but got %d", exitCode)
+		}
+		trimmed, usage := parseResourceUsage(out.Bytes())
+		if string(trimmed) != "hello\n" {
+			t.Fatalf("unexpected output %q", trimmed)
+		}
+		if usage == nil {
+			t.Fatalf("failed to get resource usage from %q", out.String())
+		}
+	})
+	t.Run("MeasureProcessResourceUsage", func(t *testing.T) {
+		var out bytes.Buffer
+		if _, err := MeasureProcessResourceUsage(context.Background(), []string{"sh", "-c", "echo hello"}, &out); err != nil {
+			t.Fatal(err)
+		}
+		if _, usage := parseResourceUsage(out.Bytes()); usage == nil {
+			t.Fatalf("failed to get resource usage from %q", out.String())
+		}
+	})
+	t.Run("measure queue worker by process", func(t *testing.T) {
+		main := TestJobContainer{Container: corev1.Container{Name: "test", Command: []string{"go", "test"}}}
+		sidecar := TestJobContainer{Container: corev1.Container{Name: "sidecar", Command: []string{"sleep", "infinity"}}}
+		podSpec := TestJobPodSpec{Containers: []TestJobContainer{sidecar, main}}
+		keyContainers := (&TaskBuilder{}).addContainersByStrategyKey(&podSpec, main, &StrategyKey{
+			ConcurrentIdx: 0,
+			Env:           "TEST",
+			Queue:         &KeyQueue{},
+			WorkerNum:     2,
+		})
+		jobSpec := &batchv1.Job{}
+		for _, container := range podSpec.Containers {
+			jobSpec.Spec.Template.Spec.Containers = append(jobSpec.Spec.Template.Spec.Containers, container.Container)
+		}
+		installedPathMap := map[string]string{"sidecar": "/agent", "test0-0": "/agent", "test0-1": "/agent"}
+		measureResourceUsageByAgent(jobSpec, installedPathMap, keyContainers)
+		containers := jobSpec.Spec.Template.Spec.Containers
+		if len(containers) != 3 {
+			t.Fatalf("failed to get containers: %d", len(containers))
+		}
+		if !reflect.DeepEqual(containers[0].Command, []string{"sleep", "infinity"}) {
+			t.Fatalf("sidecar must not be measured: %v", containers[0].Command)
+		}
+		for _, container := range containers[1:] {
+			expected := []string{"/agent", ResourceUsageCommand, ResourceUsageProcessFlag, "--", "go", "test"}
+			if !reflect.DeepEqual(container.Command, expected) {
+				t.Fatalf("worker %s must be measured by process: expected %v but got %v", container.Name, expected, container.Command)
+			}
+		}
+	})
+	t.Run("local executor", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("rusage isn't supported on windows")
+		}
+		dir, err := os.MkdirTemp("", "usage")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		exec := &localJobExecutor{
+			rootDir:   dir,
+			container: corev1.Container{Command: []string{"sh", "-c"}, Args: []string{"echo hello"}},
+		}
+		if _, err := exec.Output(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		usage := exec.ResourceUsage()
+		if usage == nil || usage.PeakMemoryBytes == 0 {
+			t.Fatalf("failed to get resource usage: %v", usage)
+		}
+	})
+}
@@ -8,6 +8,8 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 )
 
 func existsDir(path string) bool {
@@ -18,6 +20,40 @@ func existsDir(path string) bool {
 	return info.IsDir()
 }
 
+// globRegexp converts the glob pattern to the regular expression.
+// '*' matches any characters except '/', '**' matches any characters including '/' and '?' matches any single character except '/'.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					// '**/' matches zero or more directories.
+					i++
+					b.WriteString("(.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, fmt.Errorf("kubetest: invalid glob pattern %s: %w", pattern, err)
+	}
+	return re, nil
+}
+
 func getMainContainerFromTmpl(tmpl TestJobTemplateSpec) (TestJobContainer, error) {
 	if tmpl.Main != "" {
 		for _, container := range tmpl.Spec.Containers {
@@ -5,19 +5,27 @@ package v1
 
 import (
 	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
 )
 
 type Validator struct {
-	tokenNameMap    map[string]struct{}
-	repoNameMap     map[string]struct{}
-	artifactNameMap map[string]struct{}
+	tokenNameMap      map[string]struct{}
+	repoNameMap       map[string]struct{}
+	mergedRepoNameMap map[string]struct{}
+	artifactNameMap   map[string]struct{}
+	// artifactToPreStep mapping from the artifact name to the name of preStep that creates it.
+	artifactToPreStep map[string]string
 }
 
 func NewValidator() *Validator {
 	return &Validator{
-		tokenNameMap:    map[string]struct{}{},
-		repoNameMap:     map[string]struct{}{},
-		artifactNameMap: map[string]struct{}{},
+		tokenNameMap:      map[string]struct{}{},
+		repoNameMap:       map[string]struct{}{},
+		mergedRepoNameMap: map[string]struct{}{},
+		artifactNameMap:   map[string]struct{}{},
+		artifactToPreStep: map[string]string{},
 	}
 }
 
@@ -49,11 +57,33 @@ func (v *Validator) ValidateTestJobSpec(spec TestJobSpec) error {
 			return fmt.Errorf("kubetest: specified repository name '%s' is duplicated", repo.Name)
 		}
 		v.repoNameMap[repo.Name] = struct{}{}
+		if repo.Value.Merge != nil {
+			v.mergedRepoNameMap[repo.Name] = struct{}{}
+		}
+	}
+	if err := v.ValidatePreStepDependencies(spec.PreSteps, spec.MaxConcurrentPreSteps); err != nil {
+		return err
 	}
-	for _, prestep := range spec.PreSteps {
+	// validate preSteps in order of dependencies so that the artifacts of dependencies are defined.
+	dag := isPreStepDAG(spec.PreSteps, spec.MaxConcurrentPreSteps)
+	presteps, err := sortPreSteps(spec.PreSteps, dag)
+	if err != nil {
+		return err
+	}
+	deps := preStepDependencies(spec.PreSteps, dag)
+	for _, prestep := range presteps {
 		if err := v.ValidatePreStep(prestep); err != nil {
 			return err
 		}
+		if err := v.ValidatePreStepArtifactVolumes(prestep, preStepAncestors(prestep.Name, deps)); err != nil {
+			return err
+		}
+		for _, artifact := range prestep.Template.Spec.Artifacts {
+			v.artifactToPreStep[artifact.Name] = prestep.Name
+		}
+	}
+	if spec.MaxConcurrentPreSteps < 0 {
+		return fmt.Errorf("kubetest: maxConcurrentPreSteps must be greater than or equal to 0")
 	}
 	if err := v.ValidateMainStep(spec.MainStep); err != nil {
 		return err
@@ -68,6 +98,9 @@ func (v *Validator) ValidateTestJobSpec(spec TestJobSpec) error {
 			return err
 		}
 	}
+	if _, err := parseDuration(spec.Deadline); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -175,9 +208,117 @@ func (v *Validator) ValidatePreStep(prestep PreStep) error {
 	if prestep.Name == "" {
 		return fmt.Errorf("kubetest: prestep name must be specified")
 	}
+	if err := v.ValidateStepStrategy(prestep.Strategy, PreStepType); err != nil {
+		return err
+	}
 	if err := v.ValidateTestJobTemplateSpec(prestep.Template, PreStepType); err != nil {
 		return err
 	}
+	if _, err := parseDuration(prestep.Timeout); err != nil {
+		return err
+	}
+	if err := v.ValidateRetryPolicy(prestep.RetryPolicy); err != nil {
+		return err
+	}
+	if err := v.ValidatePreStepCache(prestep); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (v *Validator) ValidatePreStepCache(prestep PreStep) error {
+	cache := prestep.Cache
+	if cache == nil {
+		return nil
+	}
+	if len(prestep.Template.Spec.Artifacts) == 0 {
+		return fmt.Errorf("kubetest: prestep %s must have artifacts to use cache", prestep.Name)
+	}
+	if cache.Key == "" {
+		return fmt.Errorf("kubetest: cache.key must be specified")
+	}
+	validateRepo := func(repo string) error {
+		if _, exists := v.repoNameMap[repo]; !exists {
+			return fmt.Errorf("kubetest: repository %s used by cache.key is undefined", repo)
+		}
+		return nil
+	}
+	tmpl, err := newCacheKeyTemplate(
+		cache.Key,
+		func(repo string) (string, error) {
+			return "", validateRepo(repo)
+		},
+		func(repo string, patterns ...string) (string, error) {
+			if len(patterns) == 0 {
+				return "", fmt.Errorf("kubetest: hashFiles requires glob patterns of files")
+			}
+			for _, pattern := range patterns {
+				if _, err := globRegexp(pattern); err != nil {
+					return "", err
+				}
+			}
+			return "", validateRepo(repo)
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if err := tmpl.Execute(io.Discard, nil); err != nil {
+		return fmt.Errorf("kubetest: failed to expand cache.key %s: %w", cache.Key, err)
+	}
+	return v.ValidateCacheStore(cache.Store)
+}
+
+func (v *Validator) ValidateCacheStore(store CacheStore) error {
+	if store.Local == nil {
+		return fmt.Errorf("kubetest: cache.store must be specified")
+	}
+	if store.Local.Path == "" {
+		return fmt.Errorf("kubetest: cache.store.local.path must be specified")
+	}
+	return nil
+}
+
+func (v *Validator) ValidatePreStepDependencies(presteps []PreStep, maxConcurrentNum int) error {
+	names := map[string]struct{}{}
+	for _, prestep := range presteps {
+		if _, exists := names[prestep.Name]; exists {
+			return fmt.Errorf("kubetest: specified prestep name '%s' is duplicated", prestep.Name)
+		}
+		names[prestep.Name] = struct{}{}
+		for _, dep := range prestep.DependsOn {
+			if dep == prestep.Name {
+				return fmt.Errorf("kubetest: prestep %s cannot depend on itself", prestep.Name)
+			}
+		}
+	}
+	if _, err := sortPreSteps(presteps, isPreStepDAG(presteps, maxConcurrentNum)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ValidatePreStepArtifactVolumes validates that the preStep uses only the artifacts of its dependencies
+// as the volumes or the key source of the strategy.
+func (v *Validator) ValidatePreStepArtifactVolumes(prestep PreStep, ancestors map[string]struct{}) error {
+	artifactNames := []string{}
+	for _, volume := range prestep.Template.Spec.Volumes {
+		if volume.Artifact != nil {
+			artifactNames = append(artifactNames, volume.Artifact.Name)
+		}
+	}
+	if prestep.Strategy != nil && prestep.Strategy.Key.Source.Artifact != nil {
+		artifactNames = append(artifactNames, prestep.Strategy.Key.Source.Artifact.Name)
+	}
+	for _, name := range artifactNames {
+		creator, exists := v.artifactToPreStep[name]
+		if !exists {
+			continue
+		}
+		if _, exists := ancestors[creator]; !exists {
+			return fmt.Errorf("kubetest: prestep %s cannot use artifact %s because it doesn't depend on prestep %s", prestep.Name, name, creator)
+		}
+	}
 	return nil
 }
 
@@ -188,6 +329,12 @@ func (v *Validator) ValidateMainStep(step MainStep) error {
 	if err := v.ValidateTestJobTemplateSpec(step.Template, MainStepType); err != nil {
 		return err
 	}
+	if _, err := parseDuration(step.Timeout); err != nil {
+		return err
+	}
+	if err := v.ValidateRetryPolicy(step.RetryPolicy); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -195,9 +342,43 @@ func (v *Validator) ValidatePostStep(poststep PostStep) error {
 	if poststep.Name == "" {
 		return fmt.Errorf("kubetest: poststep name must be specified")
 	}
+	if err := v.ValidateStepStrategy(poststep.Strategy, PostStepType); err != nil {
+		return err
+	}
 	if err := v.ValidateTestJobTemplateSpec(poststep.Template, PostStepType); err != nil {
 		return err
 	}
+	if _, err := parseDuration(poststep.Timeout); err != nil {
+		return err
+	}
+	if err := v.ValidateRetryPolicy(poststep.RetryPolicy); err != nil {
+		return err
+	}
+	switch poststep.When {
+	case "", PostStepConditionAlways, PostStepConditionOnSuccess, PostStepConditionOnFailure:
+	default:
+		return fmt.Errorf("kubetest: unknown poststep when condition %s", poststep.When)
+	}
+	return nil
+}
+
+func (v *Validator) ValidateRetryPolicy(policy *RetryPolicy) error {
+	if policy == nil {
+		return nil
+	}
+	if policy.Count < 0 {
+		return fmt.Errorf("kubetest: retryPolicy.count must be a number greater than or equal to zero")
+	}
+	if _, err := parseDuration(policy.Backoff); err != nil {
+		return err
+	}
+	for _, condition := range policy.On {
+		switch condition {
+		case RetryConditionPendingTimeout, RetryConditionPreInitFailure, RetryConditionEviction, RetryConditionImagePullBackOff, RetryConditionNodeLost:
+		default:
+			return fmt.Errorf("kubetest: unknown retryPolicy.on condition %s", condition)
+		}
+	}
 	return nil
 }
 
@@ -377,10 +558,77 @@ func (v *Validator) ValidateStrategy(strategy *Strategy) error {
 	if err := v.ValidateScheduler(strategy.Scheduler); err != nil {
 		return err
 	}
+	if _, err := parseDuration(strategy.KeyTimeout); err != nil {
+		return err
+	}
+	// the key timeout stops the container, so the worker container of queue mode can't run the remaining keys.
+	if strategy.KeyTimeout != "" && strategy.Scheduler.Mode == SchedulerModeQueue {
+		return fmt.Errorf("kubetest: strategy.keyTimeout cannot be used with queue mode of strategy.scheduler")
+	}
+	if err := v.ValidateShard(strategy.Shard); err != nil {
+		return err
+	}
+	if err := v.ValidateStrategyImpact(strategy.Impact); err != nil {
+		return err
+	}
+	if err := v.ValidateStrategyQuarantine(strategy.Quarantine); err != nil {
+		return err
+	}
+	if err := v.ValidateStrategyFlakyDetection(strategy); err != nil {
+		return err
+	}
+	if strategy.Repeat < 0 {
+		return fmt.Errorf("kubetest: strategy.repeat must be greater than or equal to 0")
+	}
+	// the runs of the same key must be spread across containers, but keysPerContainer may pack them into the same container.
+	if strategy.Repeat > 1 && strategy.Scheduler.KeysPerContainer > 1 {
+		return fmt.Errorf("kubetest: strategy.repeat cannot be used with strategy.scheduler.keysPerContainer")
+	}
+	if len(strategy.Key.Matrix) > 0 && strategy.Scheduler.KeysPerContainer > 1 {
+		return fmt.Errorf("kubetest: strategy.scheduler.keysPerContainer cannot be used with strategy.key.matrix")
+	}
+	return nil
+}
+
+// ValidateStepStrategy validates the strategy of preStep or postStep.
+// retest, flakyDetection, quarantine and repeat are used to report the test results, so they are supported by mainStep only.
+func (v *Validator) ValidateStepStrategy(strategy *Strategy, stepType StepType) error {
+	if strategy == nil {
+		return nil
+	}
+	if strategy.Retest {
+		return fmt.Errorf("kubetest: strategy.retest must be specified mainStep only, but specified %s", stepType)
+	}
+	if strategy.FlakyDetection != nil {
+		return fmt.Errorf("kubetest: strategy.flakyDetection must be specified mainStep only, but specified %s", stepType)
+	}
+	if strategy.Quarantine != nil {
+		return fmt.Errorf("kubetest: strategy.quarantine must be specified mainStep only, but specified %s", stepType)
+	}
+	if strategy.Repeat > 0 {
+		return fmt.Errorf("kubetest: strategy.repeat must be specified mainStep only, but specified %s", stepType)
+	}
+	return v.ValidateStrategy(strategy)
+}
+
+func (v *Validator) ValidateStrategyFlakyDetection(strategy *Strategy) error {
+	detection := strategy.FlakyDetection
+	if detection == nil {
+		return nil
+	}
+	if strategy.Retest {
+		return fmt.Errorf("kubetest: strategy.flakyDetection cannot be used with strategy.retest")
+	}
+	if detection.Count < 0 {
+		return fmt.Errorf("kubetest: strategy.flakyDetection.count must be greater than or equal to 0")
+	}
 	return nil
 }
 
 func (v *Validator) ValidateStrategyKeySpec(spec StrategyKeySpec) error {
+	if len(spec.Matrix) > 0 {
+		return v.ValidateStrategyMatrix(spec)
+	}
 	if spec.Env == "" {
 		return fmt.Errorf("kubetest: strategy.key.env must be specified")
 	}
@@ -390,23 +638,216 @@ func (v *Validator) ValidateStrategyKeySpec(spec StrategyKeySpec) error {
 	return nil
 }
 
-func (v *Validator) ValidateStrategyKeySource(source StrategyKeySource) error {
-	if len(source.Static) == 0 && source.Dynamic == nil {
-		return fmt.Errorf("kubetest: strategy.key.source.static or strategy.key.source.dynamic must be specified")
+func (v *Validator) ValidateStrategyMatrix(spec StrategyKeySpec) error {
+	if keySourceNum(spec.Source) > 0 {
+		return fmt.Errorf("kubetest: only one of strategy.key.source or strategy.key.matrix needs to be specified")
 	}
-	if len(source.Static) > 0 && source.Dynamic != nil {
-		return fmt.Errorf("kubetest: only one of strategy.key.source.static or strategy.key.source.dynamic needs to be specified")
+	envNames := map[string]struct{}{}
+	for _, dimension := range spec.Matrix {
+		if dimension.Env == "" {
+			return fmt.Errorf("kubetest: strategy.key.matrix.env must be specified")
+		}
+		if dimension.Env == spec.Env {
+			return fmt.Errorf("kubetest: strategy.key.matrix.env %s is the same as strategy.key.env", dimension.Env)
+		}
+		if _, exists := envNames[dimension.Env]; exists {
+			return fmt.Errorf("kubetest: strategy.key.matrix.env %s is duplicated", dimension.Env)
+		}
+		envNames[dimension.Env] = struct{}{}
+		if err := v.ValidateStrategyKeySource(dimension.Source); err != nil {
+			return err
+		}
 	}
-	if source.Dynamic != nil {
+	return nil
+}
+
+func (v *Validator) ValidateStrategyKeySource(source StrategyKeySource) error {
+	switch keySourceNum(source) {
+	case 0:
+		return fmt.Errorf("kubetest: one of strategy.key.source.static, dynamic, configMap, repo or artifact must be specified")
+	case 1:
+	default:
+		return fmt.Errorf("kubetest: only one of strategy.key.source.static, dynamic, configMap, repo or artifact needs to be specified")
+	}
+	switch {
+	case source.Dynamic != nil:
 		return v.ValidateStrategyDynamicKeySource(source.Dynamic)
+	case source.ConfigMap != nil:
+		return v.ValidateStrategyConfigMapKeySource(source.ConfigMap)
+	case source.Repo != nil:
+		return v.ValidateStrategyRepositoryKeySource(source.Repo)
+	case source.Artifact != nil:
+		return v.ValidateStrategyArtifactKeySource(source.Artifact)
 	}
 	return nil
 }
 
+func keySourceNum(source StrategyKeySource) int {
+	var num int
+	if len(source.Static) > 0 {
+		num++
+	}
+	if source.Dynamic != nil {
+		num++
+	}
+	if source.ConfigMap != nil {
+		num++
+	}
+	if source.Repo != nil {
+		num++
+	}
+	if source.Artifact != nil {
+		num++
+	}
+	return num
+}
+
 func (v *Validator) ValidateStrategyDynamicKeySource(source *StrategyDynamicKeySource) error {
 	if err := v.ValidateTestJobTemplateSpec(source.Template, MainStepType); err != nil {
 		return err
 	}
+	return v.ValidateStrategyKeyFormat(source.Format)
+}
+
+func (v *Validator) ValidateStrategyConfigMapKeySource(source *StrategyConfigMapKeySource) error {
+	if source.Name == "" {
+		return fmt.Errorf("kubetest: strategy.key.source.configMap.name must be specified")
+	}
+	if source.Key == "" {
+		return fmt.Errorf("kubetest: strategy.key.source.configMap.key must be specified")
+	}
+	return v.ValidateStrategyKeyFormat(source.Format)
+}
+
+func (v *Validator) ValidateStrategyRepositoryKeySource(source *StrategyRepositoryKeySource) error {
+	if source.Name == "" {
+		return fmt.Errorf("kubetest: strategy.key.source.repo.name must be specified")
+	}
+	if _, exists := v.repoNameMap[source.Name]; !exists {
+		return fmt.Errorf("kubetest: strategy.key.source.repo.name %s is undefined", source.Name)
+	}
+	if source.Path == "" {
+		return fmt.Errorf("kubetest: strategy.key.source.repo.path must be specified")
+	}
+	if filepath.IsAbs(source.Path) || strings.HasPrefix(filepath.Clean(source.Path), "..") {
+		return fmt.Errorf("kubetest: strategy.key.source.repo.path must be the relative path in the repository: %s", source.Path)
+	}
+	return v.ValidateStrategyKeyFormat(source.Format)
+}
+
+func (v *Validator) ValidateStrategyArtifactKeySource(source *StrategyArtifactKeySource) error {
+	if source.Name == "" {
+		return fmt.Errorf("kubetest: strategy.key.source.artifact.name must be specified")
+	}
+	if _, exists := v.artifactNameMap[source.Name]; !exists {
+		return fmt.Errorf("kubetest: strategy.key.source.artifact.name %s is undefined", source.Name)
+	}
+	return v.ValidateStrategyKeyFormat(source.Format)
+}
+
+func (v *Validator) ValidateStrategyKeyFormat(format StrategyDynamicKeyFormat) error {
+	switch format {
+	case "", StrategyDynamicKeyFormatText, StrategyDynamicKeyFormatJSON:
+	default:
+		return fmt.Errorf("kubetest: unknown strategy key format %s", format)
+	}
+	return nil
+}
+
+func (v *Validator) ValidateStrategyImpact(impact *StrategyImpact) error {
+	if impact == nil {
+		return nil
+	}
+	if impact.Repo == "" {
+		return fmt.Errorf("kubetest: strategy.impact.repo must be specified")
+	}
+	if _, exists := v.repoNameMap[impact.Repo]; !exists {
+		return fmt.Errorf("kubetest: strategy.impact.repo %s is undefined", impact.Repo)
+	}
+	if _, exists := v.mergedRepoNameMap[impact.Repo]; !exists {
+		return fmt.Errorf("kubetest: strategy.impact.repo %s must specify merge", impact.Repo)
+	}
+	if len(impact.Rules) == 0 {
+		return fmt.Errorf("kubetest: strategy.impact.rules must be specified")
+	}
+	for _, rule := range impact.Rules {
+		if rule.Path == "" {
+			return fmt.Errorf("kubetest: strategy.impact.rules.path must be specified")
+		}
+		if len(rule.Keys) == 0 {
+			return fmt.Errorf("kubetest: strategy.impact.rules.keys must be specified")
+		}
+		for _, pattern := range append([]string{rule.Path}, rule.Keys...) {
+			if _, err := globRegexp(pattern); err != nil {
+				return err
+			}
+		}
+	}
+	for _, pattern := range impact.Fallback {
+		if _, err := globRegexp(pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *Validator) ValidateStrategyQuarantine(quarantine *StrategyQuarantine) error {
+	if quarantine == nil {
+		return nil
+	}
+	for _, pattern := range quarantine.Keys {
+		if _, err := globRegexp(pattern); err != nil {
+			return err
+		}
+	}
+	if source := quarantine.ConfigMap; source != nil {
+		if source.Name == "" {
+			return fmt.Errorf("kubetest: strategy.quarantine.configMap.name must be specified")
+		}
+		if source.Key == "" {
+			return fmt.Errorf("kubetest: strategy.quarantine.configMap.key must be specified")
+		}
+		if err := v.ValidateStrategyQuarantineFormat(source.Format); err != nil {
+			return err
+		}
+	}
+	if source := quarantine.Repo; source != nil {
+		if _, exists := v.repoNameMap[source.Name]; !exists {
+			return fmt.Errorf("kubetest: strategy.quarantine.repo.name %s is undefined", source.Name)
+		}
+		if source.Path == "" {
+			return fmt.Errorf("kubetest: strategy.quarantine.repo.path must be specified")
+		}
+		if filepath.IsAbs(source.Path) || strings.HasPrefix(filepath.Clean(source.Path), "..") {
+			return fmt.Errorf("kubetest: strategy.quarantine.repo.path must be the relative path in the repository: %s", source.Path)
+		}
+		if err := v.ValidateStrategyQuarantineFormat(source.Format); err != nil {
+			return err
+		}
+	}
+	if quarantine.ReleaseThreshold < 0 {
+		return fmt.Errorf("kubetest: strategy.quarantine.releaseThreshold must be a number greater than or equal to zero")
+	}
+	return nil
+}
+
+func (v *Validator) ValidateStrategyQuarantineFormat(format StrategyDynamicKeyFormat) error {
+	if format != "" && format != StrategyDynamicKeyFormatText {
+		return fmt.Errorf("kubetest: strategy.quarantine supports only text format but got %s", format)
+	}
+	return nil
+}
+
+func (v *Validator) ValidateShard(shard *Shard) error {
+	if shard == nil {
+		return nil
+	}
+	if shard.Total <= 0 {
+		return fmt.Errorf("kubetest: strategy.shard.total must be a number greater than zero")
+	}
+	if shard.Index < 0 || shard.Index >= shard.Total {
+		return fmt.Errorf("kubetest: strategy.shard.index must be a number between 0 and %d", shard.Total-1)
+	}
 	return nil
 }
 
@@ -423,6 +864,17 @@ func (v *Validator) ValidateScheduler(scheduler Scheduler) error {
 	if scheduler.MaxConcurrentNumPerPod < 0 {
 		return fmt.Errorf("kubetest: strategy.scheduler.ConcurrentNumPerPod must be a number greater than zero")
 	}
+	switch scheduler.Mode {
+	case "", SchedulerModeStatic, SchedulerModeQueue:
+	default:
+		return fmt.Errorf("kubetest: unknown strategy.scheduler.mode %s", scheduler.Mode)
+	}
+	if scheduler.KeysPerContainer < 0 {
+		return fmt.Errorf("kubetest: strategy.scheduler.keysPerContainer must be a number greater than zero")
+	}
+	if scheduler.MaxPodsPerStep < 0 {
+		return fmt.Errorf("kubetest: strategy.scheduler.maxPodsPerStep must be a number greater than zero")
+	}
 	return nil
 }
 
@@ -55,6 +55,26 @@ func (in *ArtifactVolumeSource) DeepCopy() *ArtifactVolumeSource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CacheStore) DeepCopyInto(out *CacheStore) {
+	*out = *in
+	if in.Local != nil {
+		in, out := &in.Local, &out.Local
+		*out = new(LocalCacheStore)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CacheStore.
+func (in *CacheStore) DeepCopy() *CacheStore {
+	if in == nil {
+		return nil
+	}
+	out := new(CacheStore)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ExportArtifact) DeepCopyInto(out *ExportArtifact) {
 	*out = *in
@@ -111,6 +131,21 @@ func (in *GitHubTokenSource) DeepCopy() *GitHubTokenSource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LocalCacheStore) DeepCopyInto(out *LocalCacheStore) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LocalCacheStore.
+func (in *LocalCacheStore) DeepCopy() *LocalCacheStore {
+	if in == nil {
+		return nil
+	}
+	out := new(LocalCacheStore)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *LogSpec) DeepCopyInto(out *LogSpec) {
 	*out = *in
@@ -157,6 +192,11 @@ func (in *MainStep) DeepCopyInto(out *MainStep) {
 		(*in).DeepCopyInto(*out)
 	}
 	in.Template.DeepCopyInto(&out.Template)
+	if in.RetryPolicy != nil {
+		in, out := &in.RetryPolicy, &out.RetryPolicy
+		*out = new(RetryPolicy)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MainStep.
@@ -188,6 +228,16 @@ func (in *MergeSpec) DeepCopy() *MergeSpec {
 func (in *PostStep) DeepCopyInto(out *PostStep) {
 	*out = *in
 	in.Template.DeepCopyInto(&out.Template)
+	if in.RetryPolicy != nil {
+		in, out := &in.RetryPolicy, &out.RetryPolicy
+		*out = new(RetryPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Strategy != nil {
+		in, out := &in.Strategy, &out.Strategy
+		*out = new(Strategy)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PostStep.
@@ -204,6 +254,26 @@ func (in *PostStep) DeepCopy() *PostStep {
 func (in *PreStep) DeepCopyInto(out *PreStep) {
 	*out = *in
 	in.Template.DeepCopyInto(&out.Template)
+	if in.RetryPolicy != nil {
+		in, out := &in.RetryPolicy, &out.RetryPolicy
+		*out = new(RetryPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Strategy != nil {
+		in, out := &in.Strategy, &out.Strategy
+		*out = new(Strategy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DependsOn != nil {
+		in, out := &in.DependsOn, &out.DependsOn
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Cache != nil {
+		in, out := &in.Cache, &out.Cache
+		*out = new(PreStepCache)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PreStep.
@@ -216,6 +286,22 @@ func (in *PreStep) DeepCopy() *PreStep {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PreStepCache) DeepCopyInto(out *PreStepCache) {
+	*out = *in
+	in.Store.DeepCopyInto(&out.Store)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PreStepCache.
+func (in *PreStepCache) DeepCopy() *PreStepCache {
+	if in == nil {
+		return nil
+	}
+	out := new(PreStepCache)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Report) DeepCopyInto(out *Report) {
 	*out = *in
@@ -227,7 +313,7 @@ func (in *Report) DeepCopyInto(out *Report) {
 			if (*in)[i] != nil {
 				in, out := &(*in)[i], &(*out)[i]
 				*out = new(ReportDetail)
-				**out = **in
+				(*in).DeepCopyInto(*out)
 			}
 		}
 	}
@@ -238,6 +324,11 @@ func (in *Report) DeepCopyInto(out *Report) {
 			(*out)[key] = val
 		}
 	}
+	if in.Shard != nil {
+		in, out := &in.Shard, &out.Shard
+		*out = new(Shard)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Report.
@@ -250,9 +341,40 @@ func (in *Report) DeepCopy() *Report {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReportAttemptResult) DeepCopyInto(out *ReportAttemptResult) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReportAttemptResult.
+func (in *ReportAttemptResult) DeepCopy() *ReportAttemptResult {
+	if in == nil {
+		return nil
+	}
+	out := new(ReportAttemptResult)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ReportDetail) DeepCopyInto(out *ReportDetail) {
 	*out = *in
+	if in.ElapsedTimeStats != nil {
+		in, out := &in.ElapsedTimeStats, &out.ElapsedTimeStats
+		*out = new(ReportElapsedTimeStats)
+		**out = **in
+	}
+	if in.AttemptResults != nil {
+		in, out := &in.AttemptResults, &out.AttemptResults
+		*out = make([]*ReportAttemptResult, len(*in))
+		for i := range *in {
+			if (*in)[i] != nil {
+				in, out := &(*in)[i], &(*out)[i]
+				*out = new(ReportAttemptResult)
+				**out = **in
+			}
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReportDetail.
@@ -265,6 +387,21 @@ func (in *ReportDetail) DeepCopy() *ReportDetail {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReportElapsedTimeStats) DeepCopyInto(out *ReportElapsedTimeStats) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReportElapsedTimeStats.
+func (in *ReportElapsedTimeStats) DeepCopy() *ReportElapsedTimeStats {
+	if in == nil {
+		return nil
+	}
+	out := new(ReportElapsedTimeStats)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ReportVolumeSource) DeepCopyInto(out *ReportVolumeSource) {
 	*out = *in
@@ -331,6 +468,26 @@ func (in *RepositoryVolumeSource) DeepCopy() *RepositoryVolumeSource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RetryPolicy) DeepCopyInto(out *RetryPolicy) {
+	*out = *in
+	if in.On != nil {
+		in, out := &in.On, &out.On
+		*out = make([]RetryCondition, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RetryPolicy.
+func (in *RetryPolicy) DeepCopy() *RetryPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(RetryPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Scheduler) DeepCopyInto(out *Scheduler) {
 	*out = *in
@@ -346,11 +503,46 @@ func (in *Scheduler) DeepCopy() *Scheduler {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Shard) DeepCopyInto(out *Shard) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Shard.
+func (in *Shard) DeepCopy() *Shard {
+	if in == nil {
+		return nil
+	}
+	out := new(Shard)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Strategy) DeepCopyInto(out *Strategy) {
 	*out = *in
 	in.Key.DeepCopyInto(&out.Key)
 	out.Scheduler = in.Scheduler
+	if in.Shard != nil {
+		in, out := &in.Shard, &out.Shard
+		*out = new(Shard)
+		**out = **in
+	}
+	if in.Impact != nil {
+		in, out := &in.Impact, &out.Impact
+		*out = new(StrategyImpact)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Quarantine != nil {
+		in, out := &in.Quarantine, &out.Quarantine
+		*out = new(StrategyQuarantine)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.FlakyDetection != nil {
+		in, out := &in.FlakyDetection, &out.FlakyDetection
+		*out = new(StrategyFlakyDetection)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Strategy.
@@ -363,6 +555,36 @@ func (in *Strategy) DeepCopy() *Strategy {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StrategyArtifactKeySource) DeepCopyInto(out *StrategyArtifactKeySource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StrategyArtifactKeySource.
+func (in *StrategyArtifactKeySource) DeepCopy() *StrategyArtifactKeySource {
+	if in == nil {
+		return nil
+	}
+	out := new(StrategyArtifactKeySource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StrategyConfigMapKeySource) DeepCopyInto(out *StrategyConfigMapKeySource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StrategyConfigMapKeySource.
+func (in *StrategyConfigMapKeySource) DeepCopy() *StrategyConfigMapKeySource {
+	if in == nil {
+		return nil
+	}
+	out := new(StrategyConfigMapKeySource)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *StrategyDynamicKeySource) DeepCopyInto(out *StrategyDynamicKeySource) {
 	*out = *in
@@ -379,6 +601,68 @@ func (in *StrategyDynamicKeySource) DeepCopy() *StrategyDynamicKeySource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StrategyFlakyDetection) DeepCopyInto(out *StrategyFlakyDetection) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StrategyFlakyDetection.
+func (in *StrategyFlakyDetection) DeepCopy() *StrategyFlakyDetection {
+	if in == nil {
+		return nil
+	}
+	out := new(StrategyFlakyDetection)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StrategyImpact) DeepCopyInto(out *StrategyImpact) {
+	*out = *in
+	if in.Rules != nil {
+		in, out := &in.Rules, &out.Rules
+		*out = make([]StrategyImpactRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Fallback != nil {
+		in, out := &in.Fallback, &out.Fallback
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StrategyImpact.
+func (in *StrategyImpact) DeepCopy() *StrategyImpact {
+	if in == nil {
+		return nil
+	}
+	out := new(StrategyImpact)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StrategyImpactRule) DeepCopyInto(out *StrategyImpactRule) {
+	*out = *in
+	if in.Keys != nil {
+		in, out := &in.Keys, &out.Keys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StrategyImpactRule.
+func (in *StrategyImpactRule) DeepCopy() *StrategyImpactRule {
+	if in == nil {
+		return nil
+	}
+	out := new(StrategyImpactRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *StrategyKeySource) DeepCopyInto(out *StrategyKeySource) {
 	*out = *in
@@ -392,6 +676,21 @@ func (in *StrategyKeySource) DeepCopyInto(out *StrategyKeySource) {
 		*out = new(StrategyDynamicKeySource)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.ConfigMap != nil {
+		in, out := &in.ConfigMap, &out.ConfigMap
+		*out = new(StrategyConfigMapKeySource)
+		**out = **in
+	}
+	if in.Repo != nil {
+		in, out := &in.Repo, &out.Repo
+		*out = new(StrategyRepositoryKeySource)
+		**out = **in
+	}
+	if in.Artifact != nil {
+		in, out := &in.Artifact, &out.Artifact
+		*out = new(StrategyArtifactKeySource)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StrategyKeySource.
@@ -408,6 +707,13 @@ func (in *StrategyKeySource) DeepCopy() *StrategyKeySource {
 func (in *StrategyKeySpec) DeepCopyInto(out *StrategyKeySpec) {
 	*out = *in
 	in.Source.DeepCopyInto(&out.Source)
+	if in.Matrix != nil {
+		in, out := &in.Matrix, &out.Matrix
+		*out = make([]StrategyMatrixDimension, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StrategyKeySpec.
@@ -420,6 +726,67 @@ func (in *StrategyKeySpec) DeepCopy() *StrategyKeySpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StrategyMatrixDimension) DeepCopyInto(out *StrategyMatrixDimension) {
+	*out = *in
+	in.Source.DeepCopyInto(&out.Source)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StrategyMatrixDimension.
+func (in *StrategyMatrixDimension) DeepCopy() *StrategyMatrixDimension {
+	if in == nil {
+		return nil
+	}
+	out := new(StrategyMatrixDimension)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StrategyQuarantine) DeepCopyInto(out *StrategyQuarantine) {
+	*out = *in
+	if in.Keys != nil {
+		in, out := &in.Keys, &out.Keys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ConfigMap != nil {
+		in, out := &in.ConfigMap, &out.ConfigMap
+		*out = new(StrategyConfigMapKeySource)
+		**out = **in
+	}
+	if in.Repo != nil {
+		in, out := &in.Repo, &out.Repo
+		*out = new(StrategyRepositoryKeySource)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StrategyQuarantine.
+func (in *StrategyQuarantine) DeepCopy() *StrategyQuarantine {
+	if in == nil {
+		return nil
+	}
+	out := new(StrategyQuarantine)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StrategyRepositoryKeySource) DeepCopyInto(out *StrategyRepositoryKeySource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StrategyRepositoryKeySource.
+func (in *StrategyRepositoryKeySource) DeepCopy() *StrategyRepositoryKeySource {
+	if in == nil {
+		return nil
+	}
+	out := new(StrategyRepositoryKeySource)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TestAgentSpec) DeepCopyInto(out *TestAgentSpec) {
 	*out = *in
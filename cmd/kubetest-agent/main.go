@@ -6,6 +6,7 @@ import (
 	"os"
 
 	"github.com/goccy/kubejob"
+	kubetestv1 "github.com/goccy/kubetest/api/v1"
 	"github.com/jessevdk/go-flags"
 )
 
@@ -30,7 +31,29 @@ const (
 	exitFailure = 1
 )
 
+// measure runs the command specified after "--" and reports its resource usage to kubetest.
+// If --process is specified, the resource usage is measured by rusage of the command instead of cgroup stats.
+func measure(args []string) int {
+	measureFunc := kubetestv1.MeasureResourceUsage
+	if len(args) > 0 && args[0] == kubetestv1.ResourceUsageProcessFlag {
+		measureFunc = kubetestv1.MeasureProcessResourceUsage
+		args = args[1:]
+	}
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	exitCode, err := measureFunc(context.Background(), args, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kubetest-agent: %+v", err)
+		return exitFailure
+	}
+	return exitCode
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == kubetestv1.ResourceUsageCommand {
+		os.Exit(measure(os.Args[2:]))
+	}
 	args, opt, err := parseOpt()
 	if err != nil {
 		flagsErr, ok := err.(*flags.Error)
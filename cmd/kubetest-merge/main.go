@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	kubetestv1 "github.com/goccy/kubetest/api/v1"
+	"github.com/jessevdk/go-flags"
+)
+
+type option struct {
+	Output string `description:"specify output path of merged report" short:"o" long:"output"`
+}
+
+const (
+	exitSuccess            = 0
+	exitWithFailureTestJob = 1
+	exitWithOtherError     = 2
+)
+
+func _main(args []string, opt option) (*kubetestv1.Report, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("unspecified report file paths")
+	}
+	reports := make([]*kubetestv1.Report, 0, len(args))
+	for _, path := range args {
+		report, err := kubetestv1.LoadReport(path)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	report, err := kubetestv1.MergeReports(reports)
+	if err != nil {
+		return nil, err
+	}
+	// the merged report is written to stdout only if the output path isn't specified.
+	if opt.Output == "" {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintln(os.Stdout, string(b))
+		return report, nil
+	}
+	b, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(opt.Output, b, 0644); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func parseOpt() ([]string, option, error) {
+	var opt option
+	parser := flags.NewParser(&opt, flags.Default)
+	parser.Usage = "[OPTIONS] REPORT_FILE..."
+	args, err := parser.Parse()
+	return args, opt, err
+}
+
+func main() {
+	args, opt, err := parseOpt()
+	if err != nil {
+		flagsErr, ok := err.(*flags.Error)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "kubetest-merge: unknown parsed option error: %T %v\n", err, err)
+			os.Exit(exitWithOtherError)
+		}
+		if flagsErr.Type == flags.ErrHelp {
+			return
+		}
+		os.Exit(exitWithOtherError)
+	}
+	report, err := _main(args, opt)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kubetest-merge: %+v\n", err)
+		os.Exit(exitWithOtherError)
+	}
+	if report.Status != kubetestv1.ResultStatusSuccess {
+		os.Exit(exitWithFailureTestJob)
+	}
+	os.Exit(exitSuccess)
+}
@@ -21,14 +21,17 @@ import (
 )
 
 type option struct {
-	Namespace string            `description:"specify namespace" short:"n" long:"namespace" default:"default"`
-	InCluster bool              `description:"specify whether in cluster" long:"in-cluster"`
-	Config    string            `description:"specify local kubeconfig path. ( default: $HOME/.kube/config )" short:"c" long:"config"`
-	List      string            `description:"specify path to get the list for test" long:"list"`
-	LogLevel  string            `description:"specify log level (debug/info/warn/error)" long:"log-level"`
-	DryRun    bool              `description:"specify dry run mode" long:"dry-run"`
-	Template  map[string]string `description:"specify template parameter for testjob file" long:"template"`
-	Output    string            `description:"specify output path of report" short:"o" long:"output"`
+	Namespace      string            `description:"specify namespace" short:"n" long:"namespace" default:"default"`
+	InCluster      bool              `description:"specify whether in cluster" long:"in-cluster"`
+	Config         string            `description:"specify local kubeconfig path. ( default: $HOME/.kube/config )" short:"c" long:"config"`
+	List           string            `description:"specify path to get the list for test" long:"list"`
+	LogLevel       string            `description:"specify log level (debug/info/warn/error)" long:"log-level"`
+	DryRun         bool              `description:"specify dry run mode" long:"dry-run"`
+	Template       map[string]string `description:"specify template parameter for testjob file" long:"template"`
+	Output         string            `description:"specify output path of report" short:"o" long:"output"`
+	PreviousReport string            `description:"specify path to the report of previous run to distribute keys by elapsed time" long:"previous-report"`
+	ShardIndex     int               `description:"specify index of the shard to run ( 0 <= shard-index < shard-total )" long:"shard-index"`
+	ShardTotal     int               `description:"specify total number of shards to split keys across multiple kubetest invocations" long:"shard-total"`
 }
 
 const (
@@ -108,6 +111,16 @@ func _main(args []string, opt option) (*kubetestv1.Report, error) {
 	if err := assignStaticKeys(&job, opt); err != nil {
 		return nil, err
 	}
+	if opt.PreviousReport != "" {
+		if err := job.SetPreviousReport(opt.PreviousReport); err != nil {
+			return nil, err
+		}
+	}
+	if opt.ShardTotal > 0 {
+		if err := job.SetShard(opt.ShardIndex, opt.ShardTotal); err != nil {
+			return nil, err
+		}
+	}
 	runMode := kubetestv1.RunModeKubernetes
 	if opt.DryRun {
 		runMode = kubetestv1.RunModeDryRun